@@ -2,19 +2,33 @@ package apiLib
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
-	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const version = "1.3.0"
+const version = "1.4.0"
+
+// hornbillDateTimeFormat is the format used by Hornbill for date time values, always in UTC
+const hornbillDateTimeFormat = "2006-01-02 15:04:05"
+
+// redactedValue replaces the value of any param that has been marked as secret
+const redactedValue = "********"
 
 var (
 	reg = regexp.MustCompile("^[a-zA-Z0-9_]*$")
@@ -27,15 +41,48 @@ type XmlmcInstStruct struct {
 	DavEndpoint string
 	FileError   error
 	paramsxml   string
-	statuscode  int
-	timeout     int
-	count       uint64
+	statuscode  atomic.Int64
+	timeout     time.Duration
+	count       atomic.Uint64
+	sessionMu   sync.Mutex
 	sessionID   string
 	apiKey      string
 	trace       string
 	jsonresp    bool
+	jsonreq     bool
 	userAgent   string
 	transport   *http.Transport
+	elements    []string
+	redactNames map[string]bool
+	redactList  []string
+	debugWriter io.Writer
+	streams     []paramStream
+
+	compression          bool
+	compressionThreshold int
+	compressionCounters  compressionCounters
+
+	instanceID   string
+	client       *http.Client
+	sharedClient bool
+	roundTripper http.RoundTripper
+	proxyURL     *url.URL
+	noProxy      []string
+
+	dialer         *net.Dialer
+	clientMu       sync.Mutex
+	defaultClient  *http.Client
+	methodTimeouts map[string]time.Duration
+
+	endpoints  endpoints
+	idempotent map[string]bool
+	breakers   circuitBreakers
+
+	dryRun       bool
+	dryRunWriter io.Writer
+
+	audit auditSink
+	async asyncExecutor
 }
 
 // ZoneInfoStrut is used to contain the instance zone info data
@@ -52,6 +99,56 @@ type ZoneInfoStrut struct {
 	} `json:"zoneinfo"`
 }
 
+// Response contains the result of an xmlmc call returned by InvokeResponse
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Duration   time.Duration
+	RequestID  string
+	SessionID  string
+	Endpoint   string
+	Attempts   int
+	DryRun     *DryRunRequest
+	start      time.Time
+	session    bool
+}
+
+// String returns the body of the response
+func (r *Response) String() string {
+	return string(r.Body)
+}
+
+// Decode unmarshals the body of the response into v as json or xml depending on the response content
+// err := response.Decode(&result)
+func (r *Response) Decode(v interface{}) error {
+	trimmed := bytes.TrimSpace(r.Body)
+	if strings.Contains(r.Header.Get("Content-Type"), "json") || bytes.HasPrefix(trimmed, []byte("{")) {
+		return json.Unmarshal(trimmed, v)
+	}
+	return xml.Unmarshal(trimmed, v)
+}
+
+// MethodError returns the failure state if the xmlmc method returned a status of fail, otherwise nil
+// if methodErr := response.MethodError(); methodErr != nil { log.Println(methodErr.Message) }
+func (r *Response) MethodError() *MethodError {
+	methodErr := parseMethodError(r.Body)
+	if methodErr != nil {
+		methodErr.session = r.session
+	}
+	return methodErr
+}
+
+// Err returns the MethodError as an error if the xmlmc method failed, otherwise nil,
+// so a failed method can be checked with the same classifiers as other errors
+// if err := response.Err(); apiLib.IsSessionExpired(err) { ... }
+func (r *Response) Err() error {
+	if methodErr := r.MethodError(); methodErr != nil {
+		return methodErr
+	}
+	return nil
+}
+
 // ParamAttribStruct is used to set XML attribues on an XMLMC parameter
 type ParamAttribStruct struct {
 	Name  string
@@ -62,7 +159,73 @@ type ParamAttribStruct struct {
 // and a new instance is returned.
 // conn := esp_xmlmc.NewXmlmcInstance("https://eurapi.hornbill.com/test/xmlmc/")
 func NewXmlmcInstance(servername string) *XmlmcInstStruct {
+	return NewXmlmcInstanceWithClient(servername, nil)
+}
+
+// NewXmlmcInstanceWithClient creates a new xmlc instance that sends the zone info lookup and all xmlmc and dav
+// requests with client, so a custom transport or proxy is used consistently. A nil client uses the default transport
+// conn := apiLib.NewXmlmcInstanceWithClient("testinstance", &http.Client{Transport: yourRoundTripper})
+func NewXmlmcInstanceWithClient(servername string, client *http.Client) *XmlmcInstStruct {
+	return newXmlmcInstance(context.Background(), servername, client)
+}
+
+// NewXmlmcInstanceConfigured creates a new xmlmc instance calling configure before the zone info lookup,
+// so the proxy, no proxy list, TLS options and round tripper set by configure apply to the lookup
+// as well as to xmlmc and dav requests. The lookup is limited by the timeout of the instance and
+// its error is returned rather than left in FileError
+//
+//	conn, err := apiLib.NewXmlmcInstanceConfigured("testinstance", func(conn *apiLib.XmlmcInstStruct) error {
+//		conn.SetNoProxy(".internal")
+//		return conn.SetProxy("http://proxy.internal:3128")
+//	})
+func NewXmlmcInstanceConfigured(servername string, configure func(conn *XmlmcInstStruct) error) (*XmlmcInstStruct, error) {
+	ndb := newXmlmcInstanceDefaults(nil)
+	if configure != nil {
+		if err := configure(ndb); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := ndb.timeoutContext(context.Background(), "", "")
+	defer cancel()
+	ndb.resolve(ctx, servername, ndb.httpClient())
+	if ndb.FileError != nil {
+		return nil, ndb.FileError
+	}
+	return ndb, nil
+}
+
+// newXmlmcInstance creates an instance, looking up the zone info within ctx when servername is not a url
+func newXmlmcInstance(ctx context.Context, servername string, client *http.Client) *XmlmcInstStruct {
+	ndb := newXmlmcInstanceDefaults(client)
+	ndb.resolve(ctx, servername, client)
+	return ndb
+}
+
+// newXmlmcInstanceDefaults creates an instance with the default transport and settings
+func newXmlmcInstanceDefaults(client *http.Client) *XmlmcInstStruct {
 	ndb := new(XmlmcInstStruct)
+	ndb.dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	ndb.transport = &http.Transport{
+		Proxy:               ndb.proxy,
+		DialContext:         ndb.dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	ndb.client = client
+
+	ndb.userAgent = "Go-http-client/" + version
+	ndb.timeout = 30 * time.Second
+	ndb.compressionThreshold = defaultCompressionThreshold
+	ndb.jsonresp = false
+	return ndb
+}
+
+// resolve sets the server and dav endpoint from servername, looking up the zone info with client within ctx
+// when servername is an instance id
+func (xmlmc *XmlmcInstStruct) resolve(ctx context.Context, servername string, client *http.Client) {
 	//-- TK Add Support for passing in instance name
 	matchedURL, err := regexp.MatchString(`(?i)(http|https)(?-i):\/\/([\w\-_]+(?:(?:\.[\w\-_]+)+))([\w\-\.,@?^=%&amp;:/~\+#]*[\w\-\@?^=%&amp;/~\+#])?`, servername)
 	//-- Catch Error
@@ -71,34 +234,33 @@ func NewXmlmcInstance(servername string) *XmlmcInstStruct {
 	}
 	//-- If URL then just use it
 	if matchedURL {
-		ndb.server = servername
-		ndb.DavEndpoint = strings.Replace(servername, "/xmlmc/", "/dav/", 1)
+		xmlmc.server = servername
+		xmlmc.DavEndpoint = strings.Replace(servername, "/xmlmc/", "/dav/", 1)
+		xmlmc.endpoints.add(servername)
 	} else {
 		//-- Else look it up
-		serverZoneInfo, ziErr := GetZoneInfo(servername)
-		ndb.FileError = ziErr
+		xmlmc.instanceID = servername
+		serverZoneInfo, ziErr := getZoneInfo(ctx, client, servername)
+		xmlmc.FileError = ziErr
 		if ziErr != nil {
-			return ndb
+			return
 		}
 		if serverZoneInfo.Zoneinfo.APIEndpoint != "" {
-			ndb.server = serverZoneInfo.Zoneinfo.APIEndpoint
-			ndb.DavEndpoint = serverZoneInfo.Zoneinfo.DAVEndpoint
+			xmlmc.server = serverZoneInfo.Zoneinfo.APIEndpoint
+			xmlmc.DavEndpoint = serverZoneInfo.Zoneinfo.DAVEndpoint
 		} else if serverZoneInfo.Zoneinfo.Endpoint != "" {
-			ndb.server = serverZoneInfo.Zoneinfo.Endpoint + "xmlmc/"
-			ndb.DavEndpoint = serverZoneInfo.Zoneinfo.Endpoint + "dav/"
+			xmlmc.server = serverZoneInfo.Zoneinfo.Endpoint + "xmlmc/"
+			xmlmc.DavEndpoint = serverZoneInfo.Zoneinfo.Endpoint + "dav/"
+		}
+		//-- The generic endpoint is kept as a fallback for when the api endpoint is unreachable
+		xmlmc.endpoints.add(xmlmc.server)
+		if serverZoneInfo.Zoneinfo.Endpoint != "" {
+			xmlmc.endpoints.add(serverZoneInfo.Zoneinfo.Endpoint + "xmlmc/")
 		}
 		if serverZoneInfo.Zoneinfo.Stream != "" {
-			ndb.stream = serverZoneInfo.Zoneinfo.Stream
+			xmlmc.stream = serverZoneInfo.Zoneinfo.Stream
 		}
 	}
-	ndb.transport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-	}
-
-	ndb.userAgent = "Go-http-client/" + version
-	ndb.timeout = 30
-	ndb.jsonresp = false
-	return ndb
 }
 
 // GetEndPointFromName takes an instanceID anf returns a endpoint URL
@@ -106,7 +268,7 @@ func NewXmlmcInstance(servername string) *XmlmcInstStruct {
 // serverEndpoint := GetEndPointFromName(servername)
 func GetEndPointFromName(instanceID string) (string, error) {
 	if instanceID == "" {
-		return "", errors.New("instanceID is mandatory")
+		return "", ErrInstanceIDRequired
 	}
 	instanceZoneInfo, err := GetZoneInfo(instanceID)
 	if err != nil {
@@ -122,18 +284,32 @@ func GetEndPointFromName(instanceID string) (string, error) {
 // looks up json config from https://files.hornbill.com/instances/instanceID/zoneinfo
 // instanceZoneInfo := GetZoneInfo(servername)
 func GetZoneInfo(instanceID string) (ZoneInfoStrut, error) {
+	return GetZoneInfoWithClient(instanceID, nil)
+}
+
+// GetZoneInfoWithClient takes an instance ID and returns zone information about the instance
+// using client for the lookup, a nil client uses a transport with the proxy from the environment
+// instanceZoneInfo := GetZoneInfoWithClient(servername, client)
+func GetZoneInfoWithClient(instanceID string, cl *http.Client) (ZoneInfoStrut, error) {
+	return getZoneInfo(context.Background(), cl, instanceID)
+}
+
+// getZoneInfo looks up the zone info of an instance within ctx, falling over to files.hornbill.co
+func getZoneInfo(ctx context.Context, cl *http.Client, instanceID string) (ZoneInfoStrut, error) {
 	//-- New Var based on ZoneInfoStrut
 	zoneInfo := ZoneInfoStrut{}
 	if instanceID == "" {
-		return zoneInfo, errors.New("instanceid not provided")
+		return zoneInfo, ErrInstanceIDRequired
 	}
 	//-- Get JSON Config
-	var trans *http.Transport
-	trans = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if cl == nil {
+		var trans *http.Transport
+		trans = &http.Transport{Proxy: http.ProxyFromEnvironment}
 
-	cl := &http.Client{Transport: trans, Timeout: time.Second * 30}
+		cl = &http.Client{Transport: trans, Timeout: time.Second * 30}
+	}
 
-	req, err := http.NewRequest("GET", "https://files.hornbill.com/instances/"+instanceID+"/zoneinfo", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://files.hornbill.com/instances/"+instanceID+"/zoneinfo", nil)
 	if err != nil {
 		log.Println("Could not make request: " + err.Error())
 		return zoneInfo, err
@@ -144,9 +320,14 @@ func GetZoneInfo(instanceID string) (ZoneInfoStrut, error) {
 			log.Println("Error Loading Zone Info File: " + err.Error())
 		} else {
 			log.Println("Unexpected status when attempting to load Zone Info from " + "https://files.hornbill.com/instances/" + instanceID + "/zoneinfo" + " : " + response.Status)
+			response.Body.Close()
+		}
+		//-- Give up rather than fall over once the caller has
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return zoneInfo, ctxErr
 		}
 		//-- If we fail fall over to using files.hornbill.co
-		req, err = http.NewRequest("GET", "https://files.hornbill.co/instances/"+instanceID+"/zoneinfo", nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", "https://files.hornbill.co/instances/"+instanceID+"/zoneinfo", nil)
 		if err != nil {
 			log.Println("Could not makre request: " + err.Error())
 			return zoneInfo, err
@@ -158,6 +339,13 @@ func GetZoneInfo(instanceID string) (ZoneInfoStrut, error) {
 			log.Println("Error Loading Zone Info File: " + err.Error())
 			return zoneInfo, err
 		}
+		if response.StatusCode != 200 {
+			log.Println("Unexpected status when attempting to load Zone Info from " + "https://files.hornbill.co/instances/" + instanceID + "/zoneinfo" + " : " + response.Status)
+			httpErr := &HTTPError{StatusCode: response.StatusCode, Status: response.Status, Header: response.Header}
+			httpErr.Body, _ = io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+			response.Body.Close()
+			return zoneInfo, httpErr
+		}
 	}
 	//-- Close Connection
 	defer response.Body.Close()
@@ -182,16 +370,16 @@ func GetZoneInfo(instanceID string) (ZoneInfoStrut, error) {
 func (xmlmc *XmlmcInstStruct) SetParam(strName string, varValue string) error {
 	//Make sure the tag is not empty
 	if len(strName) == 0 {
-		return errors.New("Name Must contain at least one letter or number")
+		return ErrNameEmpty
 	}
 	//Make sure the tag is only letter ans number so it will create valid XML
 	if !reg.MatchString(strName) {
-		return errors.New("Name invalid only Numbers and letters can be used")
+		return ErrNameInvalid
 	}
 	//Make sure the ivalues are valid for xml
 	cleaned, err := xmlEncodeString(varValue)
 	if err != nil {
-		return errors.New("Could not clean the varValue input")
+		return ErrEncodeValue
 	}
 	xmlmc.paramsxml = xmlmc.paramsxml + "<" + strName + ">" + cleaned + "</" + strName + ">"
 	return nil
@@ -203,102 +391,118 @@ func (xmlmc *XmlmcInstStruct) SetParam(strName string, varValue string) error {
 func (xmlmc *XmlmcInstStruct) SetParamAttr(strName string, varValue string, attribs []ParamAttribStruct) error {
 	//Make sure the tag is not empty
 	if len(strName) == 0 {
-		return errors.New("Name Must contain at least one letter or number")
+		return ErrNameEmpty
 	}
 	//Make sure the tag is only letter ans number so it will create valid XML
 	if !reg.MatchString(strName) {
-		return errors.New("Name invalid only Numbers and letters can be used")
+		return ErrNameInvalid
 	}
 	//Make sure the ivalues are valid for xml
 	cleaned, err := xmlEncodeString(varValue)
 	if err != nil {
-		return errors.New("Could not clean the varValue input")
+		return ErrEncodeValue
 	}
-	xmlmc.paramsxml = xmlmc.paramsxml + "<" + strName
-	for _, v := range attribs {
-		xmlmc.paramsxml += " " + v.Name + "=\"" + v.Value + "\""
+	attribsxml, err := encodeAttribs(attribs)
+	if err != nil {
+		return err
 	}
-	xmlmc.paramsxml = xmlmc.paramsxml + ">" + cleaned + "</" + strName + ">"
+	xmlmc.paramsxml = xmlmc.paramsxml + "<" + strName + attribsxml + ">" + cleaned + "</" + strName + ">"
 	return nil
 }
 
-// InvokeGetResponse is the call that performs the xml call.
-// You pass it the servince name and the methodname as strings.
-// It returns the body of the response as a string the http response headers and an error which should be checked
-// result, err := conn.Invoke("session", "userLogon")
-func (xmlmc *XmlmcInstStruct) InvokeGetResponse(servicename string, methodname string) (string, http.Header, error) {
-
-	//-- Add Api Tracing
-	tracename := ""
-	if xmlmc.trace != "" {
-		tracename = "/" + tracename
-	}
+// SetParamInt sets an integer parameter in an already instantiated NewXmlmcInstance connection.
+// returns an errors if this is unsuccesful
+// err := conn.SetParamInt("rowstart", 0)
+func (xmlmc *XmlmcInstStruct) SetParamInt(strName string, value int) error {
+	return xmlmc.SetParam(strName, strconv.Itoa(value))
+}
 
-	xmlmclocal := "<methodCall service=\"" + servicename + "\" method=\"" + methodname + "\" trace=\"goApi" + tracename + "\">"
-	if len(xmlmc.paramsxml) == 0 {
-		xmlmclocal = xmlmclocal + "</methodCall>"
-	} else {
-		xmlmclocal = xmlmclocal + "<params>" + xmlmc.paramsxml
-		xmlmclocal = xmlmclocal + "</params>" + "</methodCall>"
-	}
+// SetParamFloat sets a decimal parameter in an already instantiated NewXmlmcInstance connection.
+// returns an errors if this is unsuccesful
+// err := conn.SetParamFloat("h_cost", 12.5)
+func (xmlmc *XmlmcInstStruct) SetParamFloat(strName string, value float64) error {
+	return xmlmc.SetParam(strName, strconv.FormatFloat(value, 'f', -1, 64))
+}
 
-	strURL := xmlmc.server + "/" + servicename + "/?method=" + methodname
+// SetParamBool sets a boolean parameter as true or false in an already instantiated NewXmlmcInstance connection.
+// returns an errors if this is unsuccesful
+// err := conn.SetParamBool("returnMeta", true)
+func (xmlmc *XmlmcInstStruct) SetParamBool(strName string, value bool) error {
+	return xmlmc.SetParam(strName, strconv.FormatBool(value))
+}
 
-	var xmlmcstr = []byte(xmlmclocal)
+// SetParamTime sets a date time parameter in the Hornbill YYYY-MM-DD HH:MM:SS format, converted to UTC.
+// returns an errors if this is unsuccesful
+// err := conn.SetParamTime("h_datelogged", time.Now())
+func (xmlmc *XmlmcInstStruct) SetParamTime(strName string, value time.Time) error {
+	return xmlmc.SetParam(strName, value.UTC().Format(hornbillDateTimeFormat))
+}
 
-	req, err := http.NewRequest("POST", strURL, bytes.NewBuffer(xmlmcstr))
-	xmlmc.count++
+// SetParamBytes sets a binary parameter encoded as base64 in an already instantiated NewXmlmcInstance connection.
+// returns an errors if this is unsuccesful
+// err := conn.SetParamBytes("content", fileBytes)
+func (xmlmc *XmlmcInstStruct) SetParamBytes(strName string, value []byte) error {
+	return xmlmc.SetParam(strName, base64.StdEncoding.EncodeToString(value))
+}
 
-	if err != nil {
-		return "", nil, errors.New("Unable to create http request in esp_xmlmc.go")
+// SetParamReader sets a parameter whose content is read from r and base64 encoded as the request is sent,
+// so large files are streamed rather than held in memory. r must remain readable until Invoke is called
+// and streamed params can not be sent with SetJSONRequest(true)
+// returns an errors if this is unsuccesful
+// err := conn.SetParamReader("content", file)
+func (xmlmc *XmlmcInstStruct) SetParamReader(strName string, r io.Reader) error {
+	if err := xmlmc.SetParam(strName, ""); err != nil {
+		return err
 	}
+	offset := len(xmlmc.paramsxml) - len("</"+strName+">")
+	xmlmc.streams = append(xmlmc.streams, paramStream{offset: offset, r: r})
+	return nil
+}
 
-	req.Header.Set("Content-Type", "text/xmlmc")
-	if xmlmc.apiKey != "" {
-		req.Header.Add("Authorization", "ESP-APIKEY "+xmlmc.apiKey)
-	}
-	req.Header.Set("User-Agent", xmlmc.userAgent)
-	req.Header.Add("Cookie", xmlmc.sessionID)
-	if xmlmc.jsonresp == true {
-		req.Header.Add("Accept", "text/json")
-	}
-	duration := time.Second * time.Duration(xmlmc.timeout)
-	var client *http.Client
-	if xmlmc.transport == nil {
-		log.Println("xmlmc.transport is nil")
-		client = &http.Client{Timeout: duration}
-	} else {
-		client = &http.Client{Transport: xmlmc.transport, Timeout: duration}
-	}
-	resp, err := client.Do(req)
+// SetParamSecret sets a base64 encoded parameter such as a password and marks the parameter
+// so its value is redacted from GetParam, dry runs, debug output and logs.
+// returns an errors if this is unsuccesful
+// err := conn.SetParamSecret("password", "Password")
+func (xmlmc *XmlmcInstStruct) SetParamSecret(strName string, value string) error {
+	err := xmlmc.SetParam(strName, base64.StdEncoding.EncodeToString([]byte(value)))
 	if err != nil {
-		return "", nil, err
+		return err
 	}
-	xmlmc.statuscode = resp.StatusCode
+	xmlmc.AddRedactedParams(strName)
+	return nil
+}
 
-	defer resp.Body.Close()
-	//-- Check for HTTP Response
-	if resp.StatusCode != 200 {
-		errorString := fmt.Sprintf("Invalid HTTP Response: %d", resp.StatusCode)
-		err = errors.New(errorString)
-		//Drain the body so we can reuse the connection
-		io.Copy(io.Discard, resp.Body)
-		return "", nil, err
+// encodeAttribs validates the attribute names and returns the escaped attributes ready to be
+// added to an element start tag
+func encodeAttribs(attribs []ParamAttribStruct) (string, error) {
+	attribsxml := ""
+	for _, v := range attribs {
+		//Attribute names follow the same rules as element names
+		if len(v.Name) == 0 {
+			return "", ErrAttributeNameEmpty
+		}
+		if !reg.MatchString(v.Name) {
+			return "", ErrAttributeNameInvalid
+		}
+		cleaned, err := xmlEncodeString(v.Value)
+		if err != nil {
+			return "", ErrEncodeValue
+		}
+		attribsxml += " " + v.Name + "=\"" + cleaned + "\""
 	}
+	return attribsxml, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// InvokeGetResponse is the call that performs the xml call.
+// You pass it the servince name and the methodname as strings.
+// It returns the body of the response as a string the http response headers and an error which should be checked
+// result, headers, err := conn.InvokeGetResponse("session", "userLogon")
+func (xmlmc *XmlmcInstStruct) InvokeGetResponse(servicename string, methodname string) (string, http.Header, error) {
+	response, err := xmlmc.InvokeResponse(servicename, methodname)
 	if err != nil {
-		return "", nil, errors.New("Cant read the body of the response")
-	}
-	// If we have a new EspSessionId set it
-	SessionIds := strings.Split(resp.Header.Get("Set-Cookie"), ";")
-
-	if SessionIds[0] != "" {
-		xmlmc.sessionID = SessionIds[0]
+		return "", nil, err
 	}
-
-	xmlmc.paramsxml = ""
-	return string(body), resp.Header, nil
+	return response.String(), response.Header, nil
 }
 
 // Invoke is the call that performs the xml call.
@@ -306,92 +510,309 @@ func (xmlmc *XmlmcInstStruct) InvokeGetResponse(servicename string, methodname s
 // It returns the body of the response as a string and an error which should be checked
 // result, err := conn.Invoke("session", "userLogon")
 func (xmlmc *XmlmcInstStruct) Invoke(servicename string, methodname string) (string, error) {
-
-	//-- Add Api Tracing
-	tracename := ""
-	if xmlmc.trace != "" {
-		tracename = "/" + tracename
+	response, err := xmlmc.InvokeResponse(servicename, methodname)
+	if err != nil {
+		return "", err
 	}
+	return response.String(), nil
+}
 
-	xmlmclocal := "<methodCall service=\"" + servicename + "\" method=\"" + methodname + "\" trace=\"goApi" + tracename + "\">"
-	if len(xmlmc.paramsxml) == 0 {
-		xmlmclocal = xmlmclocal + "</methodCall>"
-	} else {
-		xmlmclocal = xmlmclocal + "<params>" + xmlmc.paramsxml
-		xmlmclocal = xmlmclocal + "</params>" + "</methodCall>"
+// InvokeResponse is the call that performs the xml call.
+// You pass it the servince name and the methodname as strings.
+// It returns a Response holding the body, headers, status and timing of the call and an error which should be checked
+// The params are cleared once the call has succeeded
+// response, err := conn.InvokeResponse("session", "userLogon")
+func (xmlmc *XmlmcInstStruct) InvokeResponse(servicename string, methodname string) (*Response, error) {
+	call, err := xmlmc.newMethodCall(servicename, methodname)
+	if err != nil {
+		return nil, err
 	}
+	response, err := xmlmc.invoke(call)
+	if err != nil {
+		return nil, err
+	}
+	xmlmc.ClearParam()
+	return response, nil
+}
 
-	strURL := xmlmc.server + "/" + servicename + "/?method=" + methodname
+// methodCall is everything needed to send a single xmlmc request
+type methodCall struct {
+	service     string
+	method      string
+	body        []byte
+	contentType string
+	streams     []paramStream
+	paramsxml   string
+	ctx         context.Context
+}
 
-	var xmlmcstr = []byte(xmlmclocal)
+// callerDone returns true if the context the call was made with is cancelled or past its deadline
+func (call *methodCall) callerDone() bool {
+	return call.ctx != nil && call.ctx.Err() != nil
+}
 
-	req, err := http.NewRequest("POST", strURL, bytes.NewBuffer(xmlmcstr))
-	xmlmc.count++
+// newMethodCall captures the currently set params as a methodCall ready to be sent
+func (xmlmc *XmlmcInstStruct) newMethodCall(servicename string, methodname string) (*methodCall, error) {
+	//-- Refuse to send malformed params
+	if len(xmlmc.elements) > 0 {
+		return nil, xmlmc.unclosedError()
+	}
+	if len(xmlmc.streams) > 0 && xmlmc.jsonreq {
+		return nil, ErrStreamedJSON
+	}
+	body, contentType, err := xmlmc.buildRequestBody(servicename, methodname)
+	if err != nil {
+		return nil, err
+	}
+	call := &methodCall{service: servicename, method: methodname, body: body, contentType: contentType, paramsxml: xmlmc.paramsxml}
+	//-- Streams are positioned within the params so move them to their position in the body
+	paramsStart := len(body) - len(xmlmc.paramsxml) - len("</params></methodCall>")
+	for _, stream := range xmlmc.streams {
+		call.streams = append(call.streams, paramStream{offset: paramsStart + stream.offset, r: stream.r})
+	}
+	return call, nil
+}
 
+// invoke sends the call to the server and reads the response
+func (xmlmc *XmlmcInstStruct) invoke(call *methodCall) (*Response, error) {
+	start := time.Now()
+	resp, response, err := xmlmc.send(call)
 	if err != nil {
-		return "", errors.New("Unable to create http request in esp_xmlmc.go")
+		xmlmc.auditCall(call, nil, err, start)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Content-Type", "text/xmlmc")
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrReadBody, err)
+		xmlmc.auditCall(call, response, err, start)
+		return nil, err
+	}
+	response.Body = body
+	response.Duration = time.Since(response.start)
+	xmlmc.auditCall(call, response, nil, start)
+	return response, nil
+}
+
+// send sends the call to the first healthy endpoint, failing over to the next when the endpoint is unreachable
+// and the call can safely be sent again. It returns the http response with its body still to be read
+// along with a Response holding everything but the body
+func (xmlmc *XmlmcInstStruct) send(call *methodCall) (*http.Response, *Response, error) {
+	if xmlmc.dryRun {
+		return xmlmc.dryRunSend(call)
+	}
+	servers := xmlmc.candidates()
+	var lastErr error
+	attempts := 0
+	for i, server := range servers {
+		//-- Endpoints whose circuit is open are skipped without a request
+		if err := xmlmc.breakers.allow(server); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			continue
+		}
+		attempts++
+		resp, response, err := xmlmc.sendTo(call, server)
+		//-- A call the caller cancelled or let pass its deadline says nothing about the endpoint,
+		//-- and the next endpoint would fail straight away with the same context
+		if err != nil && call.callerDone() {
+			xmlmc.breakers.release(server)
+			return nil, nil, err
+		}
+		xmlmc.markEndpoint(server, err)
+		xmlmc.breakers.record(server, err)
+		if err == nil {
+			response.Attempts = attempts
+			return resp, response, nil
+		}
+		lastErr = err
+		if i == len(servers)-1 || !xmlmc.canFailover(call, err) {
+			return nil, nil, err
+		}
+		xmlmc.debugFailover(server, err)
+	}
+	if lastErr == nil {
+		lastErr = ErrNoEndpoint
+	}
+	return nil, nil, lastErr
+}
+
+// setHeaders sets the headers of an xmlmc request
+func (xmlmc *XmlmcInstStruct) setHeaders(req *http.Request, call *methodCall, requestID string) {
+	req.Header.Set("Content-Type", call.contentType)
 	if xmlmc.apiKey != "" {
 		req.Header.Add("Authorization", "ESP-APIKEY "+xmlmc.apiKey)
 	}
 	req.Header.Set("User-Agent", xmlmc.userAgent)
-	req.Header.Add("Cookie", xmlmc.sessionID)
+	req.Header.Add("Cookie", xmlmc.GetSessionID())
+	req.Header.Set("X-Request-Id", requestID)
 	if xmlmc.jsonresp == true {
 		req.Header.Add("Accept", "text/json")
 	}
-	duration := time.Second * time.Duration(xmlmc.timeout)
-	var client *http.Client
-	if xmlmc.transport == nil {
-		log.Println("xmlmc.transport is nil")
-		client = &http.Client{Timeout: duration}
-	} else {
-		client = &http.Client{Transport: xmlmc.transport, Timeout: duration}
+	if xmlmc.compression {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
 	}
-	if req == nil {
-		log.Println("Endpoint:", strURL)
-		log.Println("Payload:", string(xmlmcstr))
-		log.Println("Transport:", fmt.Sprintf("%+v", xmlmc.transport))
-		log.Println("Duration:", duration)
-		return "", errors.New("req has a nil value")
+}
+
+// sendTo sends the call to one endpoint
+func (xmlmc *XmlmcInstStruct) sendTo(call *methodCall, server string) (resp *http.Response, response *Response, err error) {
+	strURL := server + "/" + call.service + "/?method=" + call.method
+
+	ctx, cancel := xmlmc.timeoutContext(call.ctx, call.service, call.method)
+	req, err := http.NewRequestWithContext(ctx, "POST", strURL, call.requestBody())
+	xmlmc.count.Add(1)
+
+	if err != nil {
+		cancel()
+		//-- The payload is left to SetDebugWriter rather than logged
+		return nil, nil, fmt.Errorf("%w: %w", ErrCreateRequest, err)
 	}
 
+	requestID := newRequestID()
+	xmlmc.setHeaders(req, call, requestID)
+	xmlmc.compressRequest(req, call)
+	xmlmc.debugRequest(req, call)
+	client := xmlmc.httpClient()
+
 	defer func() {
-		if err := recover(); err != nil {
-			log.Println("Panic caught:", err)
+		if r := recover(); r != nil {
+			log.Println("Panic caught:", r)
+			resp, response = nil, nil
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
 		}
 	}()
 
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err = client.Do(req)
+	xmlmc.debugResponse(resp, err, time.Since(start))
 	if err != nil {
-		return "", err
+		cancel()
+		return nil, nil, err
+	}
+	//-- The timeout covers reading the body so only cancel once it is closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	xmlmc.statuscode.Store(int64(resp.StatusCode))
+	if err = xmlmc.decompressResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, nil, err
 	}
-	xmlmc.statuscode = resp.StatusCode
-
-	defer resp.Body.Close()
 
 	//-- Check for HTTP Response
 	if resp.StatusCode != 200 {
-		errorString := fmt.Sprintf("Invalid HTTP Response: %d", resp.StatusCode)
-		err = errors.New(errorString)
+		//-- Calls without an API key are authenticated by the session
+		httpErr := &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, session: xmlmc.apiKey == ""}
+		httpErr.Body, _ = io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
 		//Drain the body so we can reuse the connection
-		io.Copy(io.Discard, resp.Body)
-		return "", err
+		drained, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		httpErr.Truncated = drained > 0
+		httpErr.MethodError = parseMethodError(httpErr.Body)
+		if httpErr.MethodError != nil {
+			httpErr.MethodError.session = httpErr.session
+		}
+		return nil, nil, httpErr
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", errors.New("Cant read the body of the response")
-	}
 	// If we have a new EspSessionId set it
 	SessionIds := strings.Split(resp.Header.Get("Set-Cookie"), ";")
 	if SessionIds[0] != "" {
-		xmlmc.sessionID = SessionIds[0]
+		xmlmc.SetSessionID(SessionIds[0])
 	}
 
-	xmlmc.paramsxml = ""
-	return string(body), nil
+	return resp, &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		RequestID:  requestID,
+		SessionID:  xmlmc.GetSessionID(),
+		Endpoint:   server,
+		Attempts:   1,
+		start:      start,
+		session:    xmlmc.apiKey == "",
+	}, nil
+}
+
+// InvokeStream is the call that performs the xml call without buffering the response.
+// You pass it the servince name and the methodname as strings.
+// It returns the body of the response as a stream which must be closed and an error which should be checked
+// The timeout set with SetTimeout includes the time taken to read the body.
+// A method returning a status of fail is not an error as the body has not been read, use NewRowDecoder,
+// which returns the MethodError from Next, or check the result once it has been read
+// body, err := conn.InvokeStream("data", "queryExec")
+func (xmlmc *XmlmcInstStruct) InvokeStream(servicename string, methodname string) (io.ReadCloser, error) {
+	call, err := xmlmc.newMethodCall(servicename, methodname)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, response, err := xmlmc.send(call)
+	xmlmc.auditCall(call, response, err, start)
+	if err != nil {
+		return nil, err
+	}
+	xmlmc.ClearParam()
+	return resp.Body, nil
+}
+
+// InvokeToWriter is the call that performs the xml call and copies the response to w as it is received.
+// You pass it the servince name, the methodname and the writer.
+// It returns the number of bytes written and an error which should be checked.
+// As with InvokeStream a method returning a status of fail is written to w without an error
+// written, err := conn.InvokeToWriter("data", "queryExec", file)
+func (xmlmc *XmlmcInstStruct) InvokeToWriter(servicename string, methodname string, w io.Writer) (int64, error) {
+	body, err := xmlmc.InvokeStream(servicename, methodname)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return io.Copy(w, body)
+}
+
+// newRequestID returns a random id sent with each request so it can be traced in logs
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// buildRequestBody returns the methodCall for the currently set params and its content type,
+// as XML by default or as JSON when SetJSONRequest(true) has been called
+func (xmlmc *XmlmcInstStruct) buildRequestBody(servicename string, methodname string) ([]byte, string, error) {
+	return xmlmc.buildMethodCallBody(servicename, methodname, xmlmc.paramsxml)
+}
+
+// buildMethodCallBody returns the methodCall for paramsxml and its content type
+func (xmlmc *XmlmcInstStruct) buildMethodCallBody(servicename string, methodname string, paramsxml string) ([]byte, string, error) {
+	//-- Add Api Tracing
+	tracename := "goApi"
+	if xmlmc.trace != "" {
+		tracename = tracename + "/" + xmlmc.trace
+	}
+
+	if xmlmc.jsonreq {
+		body, err := buildJSONMethodCall(servicename, methodname, tracename, paramsxml)
+		return body, "application/json", err
+	}
+
+	xmlmclocal := "<methodCall service=\"" + servicename + "\" method=\"" + methodname + "\" trace=\"" + tracename + "\">"
+	if len(paramsxml) == 0 {
+		xmlmclocal = xmlmclocal + "</methodCall>"
+	} else {
+		xmlmclocal = xmlmclocal + "<params>" + paramsxml
+		xmlmclocal = xmlmclocal + "</params>" + "</methodCall>"
+	}
+	return []byte(xmlmclocal), "text/xmlmc", nil
+}
+
+// SetJSONRequest sends the params as a JSON methodCall rather than xml.
+// Params are still built with SetParam and OpenElement.
+// Expects a bool of true or false
+// conn.SetJSONRequest(true)
+func (xmlmc *XmlmcInstStruct) SetJSONRequest(b bool) {
+	xmlmc.jsonreq = b
 }
 
 // SetJSONResponse returns the xml response as json.
@@ -404,6 +825,8 @@ func (xmlmc *XmlmcInstStruct) SetJSONResponse(b bool) {
 // GetSessionID returins the current set ESPsessionID for this XmlmcInstance
 // sessID := conn.GetSessionID()
 func (xmlmc *XmlmcInstStruct) GetSessionID() string {
+	xmlmc.sessionMu.Lock()
+	defer xmlmc.sessionMu.Unlock()
 	return xmlmc.sessionID
 }
 
@@ -416,6 +839,8 @@ func (xmlmc *XmlmcInstStruct) SetAPIKey(s string) {
 // SetSessionID sets the current ESPsessionID for this XmlmcInstance it expects a a string to be passed
 // conn.SetSessionID()
 func (xmlmc *XmlmcInstStruct) SetSessionID(s string) {
+	xmlmc.sessionMu.Lock()
+	defer xmlmc.sessionMu.Unlock()
 	xmlmc.sessionID = s
 }
 
@@ -441,7 +866,7 @@ func (xmlmc *XmlmcInstStruct) GetServerStream() string {
 // returns an integer
 // status := conn.GetStatusCode()
 func (xmlmc *XmlmcInstStruct) GetStatusCode() int {
-	return xmlmc.statuscode
+	return int(xmlmc.statuscode.Load())
 }
 
 // OpenElement is called to create complex xmlmc requests.
@@ -450,38 +875,96 @@ func (xmlmc *XmlmcInstStruct) GetStatusCode() int {
 // You should always have a matching close tag to match this
 // err := conn.OpenElement("UserID")
 func (xmlmc *XmlmcInstStruct) OpenElement(elementname string) error {
+	return xmlmc.OpenElementAttr(elementname, nil)
+}
+
+// OpenElementAttr is called to create complex xmlmc requests where the element has attributes.
+// The name of the element to create and its attributes should be passed in
+// It will return an error if the element or an attribute name is invalid
+// You should always have a matching close tag to match this
+// err := conn.OpenElementAttr("UserID", yourAttribsArray)
+func (xmlmc *XmlmcInstStruct) OpenElementAttr(elementname string, attribs []ParamAttribStruct) error {
 	//Make sure the element is not empty
 	if len(elementname) == 0 {
-		return errors.New("Element must have at least one letter or number")
+		return ErrElementEmpty
 	}
 	if !reg.MatchString(elementname) {
-		return errors.New("Element invalid only Numbers and letters can be used")
+		return ErrElementInvalid
+	}
+	attribsxml, err := encodeAttribs(attribs)
+	if err != nil {
+		return err
 	}
-	xmlmc.paramsxml = xmlmc.paramsxml + "<" + elementname + ">"
+	xmlmc.paramsxml = xmlmc.paramsxml + "<" + elementname + attribsxml + ">"
+	xmlmc.elements = append(xmlmc.elements, elementname)
 	return nil
 }
 
 // CloseElement is called to close a previously opened OpenElement
 // The name of the element to create should be passed in
-// It will return an error if the element name is invalid
+// It will return an error if the element name is invalid or does not match the last opened element
 // err := conn.CloseElement("UserID")
 func (xmlmc *XmlmcInstStruct) CloseElement(elementname string) error {
 	//Make sure the element is not empty
 	if len(elementname) == 0 {
-		return errors.New("Element must have at least one letter or number")
+		return ErrElementEmpty
 	}
 	if !reg.MatchString(elementname) {
-		return errors.New("Element invalid only Numbers and letters can be used")
+		return ErrElementInvalid
 	}
+	//Make sure we are closing the element that was opened last
+	if len(xmlmc.elements) == 0 {
+		return fmt.Errorf("%w: element %s has not been opened", ErrUnbalancedElements, elementname)
+	}
+	if open := xmlmc.elements[len(xmlmc.elements)-1]; open != elementname {
+		return fmt.Errorf("%w: element %s does not match open element %s", ErrUnbalancedElements, elementname, open)
+	}
+	xmlmc.elements = xmlmc.elements[:len(xmlmc.elements)-1]
 	xmlmc.paramsxml = xmlmc.paramsxml + "</" + elementname + ">"
 	return nil
 }
 
-// SetTimeout allows you to set a maximum timeout for the http request in seconds.
-// It defaults to 0 which means no timeout
-// This should probably be set to 30 seconds for most requests and should be set before Invoke is called
-// conn.SetTimeout = 30
+// WithElement opens an element, calls fn to add its children and then closes it.
+// If fn returns an error anything added since the element was opened is removed
+// so the params are always left balanced
+// err := conn.WithElement("customFields", func() error { return conn.SetParam("h_custom_a", "1") })
+func (xmlmc *XmlmcInstStruct) WithElement(elementname string, fn func() error) error {
+	paramsLen := len(xmlmc.paramsxml)
+	depth := len(xmlmc.elements)
+	if err := xmlmc.OpenElement(elementname); err != nil {
+		return err
+	}
+	streams := len(xmlmc.streams)
+	if err := fn(); err != nil {
+		xmlmc.rollbackParams(paramsLen, depth, streams)
+		return err
+	}
+	if len(xmlmc.elements) != depth+1 {
+		xmlmc.rollbackParams(paramsLen, depth, streams)
+		return fmt.Errorf("%w: element %s has unclosed child elements", ErrUnbalancedElements, elementname)
+	}
+	return xmlmc.CloseElement(elementname)
+}
+
+// rollbackParams removes anything added to the params since they had the given sizes
+func (xmlmc *XmlmcInstStruct) rollbackParams(paramsLen int, depth int, streams int) {
+	xmlmc.paramsxml = xmlmc.paramsxml[:paramsLen]
+	xmlmc.elements = xmlmc.elements[:depth]
+	xmlmc.streams = xmlmc.streams[:streams]
+}
+
+// SetTimeout allows you to set a maximum timeout for the http request in seconds, including reading the response.
+// It defaults to 30 seconds and 0 means no timeout
+// This should be set before Invoke is called, SetTimeoutDuration allows finer control
+// conn.SetTimeout(30)
 func (xmlmc *XmlmcInstStruct) SetTimeout(timeout int) {
+	xmlmc.timeout = time.Duration(timeout) * time.Second
+}
+
+// SetTimeoutDuration allows you to set a maximum timeout for the http request, including reading the response.
+// It defaults to 30 seconds and 0 means no timeout
+// conn.SetTimeoutDuration(90 * time.Second)
+func (xmlmc *XmlmcInstStruct) SetTimeoutDuration(timeout time.Duration) {
 	xmlmc.timeout = timeout
 }
 
@@ -490,23 +973,68 @@ func xmlEncodeString(strValue string) (string, error) {
 	buf := new(bytes.Buffer)
 	err := xml.EscapeText(buf, []byte(strValue))
 	if err != nil {
-		return "", errors.New(err.Error())
+		return "", fmt.Errorf("%w: %w", ErrEncodeValue, err)
 	}
 	return buf.String(), nil
 }
 
 // GetParam Allows you to get the xml you would be sending to the server.
-// It returns a string of the xml
+// It returns a string of the xml with the values of sensitive params redacted, see SetRedactedParams
 // xmlmc := conn.GetParam()
 func (xmlmc *XmlmcInstStruct) GetParam() string {
 
-	return "<params>" + xmlmc.paramsxml + "</params>"
+	return "<params>" + redactParams(xmlmc.paramsxml, xmlmc.isRedacted) + "</params>"
+}
+
+// redactParams replaces the content of every element whose name matches redact with redactedValue.
+// Anything after an element that can not be parsed is redacted too so nothing leaks from partial params
+func redactParams(paramsxml string, redact func(name string) bool) string {
+	var out strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(paramsxml))
+	last, start, depth := 0, -1, 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if start != -1 {
+				depth++
+			} else if redact(t.Name.Local) {
+				start = int(decoder.InputOffset())
+			}
+		case xml.EndElement:
+			if start == -1 {
+				continue
+			}
+			if depth > 0 {
+				depth--
+				continue
+			}
+			out.WriteString(paramsxml[last:start] + redactedValue)
+			last = int(decoder.InputOffset()) - len("</"+t.Name.Local+">")
+			start = -1
+		}
+	}
+	if start != -1 {
+		out.WriteString(paramsxml[last:start] + redactedValue)
+		return out.String()
+	}
+	out.WriteString(paramsxml[last:])
+	return out.String()
 }
 
 // ClearParam Allows you to blank any parms you have already set on a XmlmcInstance
 // conn.ClearParam()
 func (xmlmc *XmlmcInstStruct) ClearParam() {
 	xmlmc.paramsxml = ""
+	xmlmc.elements = nil
+	xmlmc.streams = nil
+}
+
+func (xmlmc *XmlmcInstStruct) unclosedError() error {
+	return fmt.Errorf("%w: unclosed elements %s", ErrUnbalancedElements, strings.Join(xmlmc.elements, ", "))
 }
 
 // SetUserAgent Sets a new userAgent to be passed in so we can identify who is sending the requests
@@ -520,5 +1048,5 @@ func (xmlmc *XmlmcInstStruct) SetUserAgent(ua string) {
 // xmlmc := conn.GetCount()
 func (xmlmc *XmlmcInstStruct) GetCount() uint64 {
 
-	return xmlmc.count
+	return xmlmc.count.Load()
 }
@@ -1,10 +1,16 @@
 package apiLib
 
 import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 var setParamTests = []struct {
@@ -109,6 +115,7 @@ func TestCloseElements(t *testing.T) {
 
 	for _, tt := range setElementTests {
 		conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+		_ = conn.OpenElement(tt.tagName)
 		err := conn.CloseElement(tt.tagName)
 		if err != nil {
 			if tt.out != err.Error() {
@@ -122,14 +129,330 @@ func TestCloseElements(t *testing.T) {
 	}
 }
 
+func TestElementStack(t *testing.T) {
+	conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+	if err := conn.CloseElement("User"); err == nil {
+		t.Errorf("Was expecting an error closing an element that was not opened")
+	}
+	_ = conn.OpenElement("User")
+	_ = conn.OpenElement("Name")
+	if err := conn.CloseElement("User"); err == nil {
+		t.Errorf("Was expecting an error closing User while Name is open")
+	}
+	if _, err := conn.Invoke("system", "pingCheck"); err == nil {
+		t.Errorf("Was expecting Invoke to refuse unclosed elements")
+	}
+	_ = conn.CloseElement("Name")
+	_ = conn.CloseElement("User")
+	if conn.GetParam() != "<params><User><Name></Name></User></params>" {
+		t.Errorf("Unexpected params %s\n", conn.GetParam())
+	}
+}
+
+func TestWithElement(t *testing.T) {
+	conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+	err := conn.WithElement("User", func() error {
+		return conn.SetParam("Name", "admin")
+	})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	err = conn.WithElement("Bad", func() error {
+		_ = conn.SetParam("Name", "admin")
+		return conn.OpenElement("Unclosed")
+	})
+	if err == nil {
+		t.Errorf("Was expecting an error for an unclosed child element")
+	}
+	err = conn.WithElement("Failed", func() error {
+		_ = conn.SetParam("Name", "admin")
+		return conn.SetParam("", "blank")
+	})
+	if err == nil {
+		t.Errorf("Was expecting the error from fn to be returned")
+	}
+	if conn.GetParam() != "<params><User><Name>admin</Name></User></params>" {
+		t.Errorf("Unexpected params %s\n", conn.GetParam())
+	}
+}
+
+var setParamAttrTests = []struct {
+	attribs []ParamAttribStruct
+	out     string
+	xml     string
+}{
+	{[]ParamAttribStruct{{"type", "text"}}, "", `<params><User type="text">admin</User></params>`},
+	{[]ParamAttribStruct{{"type", `a"b<c>&`}}, "", `<params><User type="a&#34;b&lt;c&gt;&amp;">admin</User></params>`},
+	{[]ParamAttribStruct{{"", "text"}}, "Attribute name must contain at least one letter or number", "<params></params>"},
+	{[]ParamAttribStruct{{`type="x" onload`, "text"}}, "Attribute name invalid only Numbers and letters can be used", "<params></params>"},
+}
+
+func TestSetParamAttr(t *testing.T) {
+	for _, tt := range setParamAttrTests {
+		conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+		err := conn.SetParamAttr("User", "admin", tt.attribs)
+		if err != nil && tt.out != err.Error() {
+			t.Errorf("for: %v got: %s but want: %s\n", tt.attribs, err, tt.out)
+		}
+		if err == nil && tt.out != "" {
+			t.Errorf("for: %v got no error but want: %s\n", tt.attribs, tt.out)
+		}
+		if conn.GetParam() != tt.xml {
+			t.Errorf("for: %v got: %s but want: %s\n", tt.attribs, conn.GetParam(), tt.xml)
+		}
+	}
+}
+
+func TestOpenElementAttr(t *testing.T) {
+	conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+	_ = conn.OpenElementAttr("User", []ParamAttribStruct{{"id", "1'2"}})
+	_ = conn.CloseElement("User")
+	if conn.GetParam() != `<params><User id="1&#39;2"></User></params>` {
+		t.Errorf("Unexpected params %s\n", conn.GetParam())
+	}
+}
+
+// checkWellFormed reads every token of the xml and returns the first syntax error
+func checkWellFormed(s string) error {
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func FuzzSetParamAttr(f *testing.F) {
+	f.Add("admin", "text")
+	f.Add("<![CDATA[!\"£$%^&*]]>", `"><injected a="`)
+	f.Add("\x00\xff", "'&#")
+	f.Fuzz(func(t *testing.T, value string, attrValue string) {
+		conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+		attribs := []ParamAttribStruct{{"type", attrValue}}
+		if err := conn.OpenElementAttr("User", attribs); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.SetParamAttr("Name", value, attribs); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.SetParam("Other", value); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.CloseElement("User"); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkWellFormed(conn.GetParam()); err != nil {
+			t.Errorf("params not well formed: %s: %s", err, conn.GetParam())
+		}
+	})
+}
+
+func TestTypedParams(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	_ = conn.SetParamInt("rowstart", -5)
+	_ = conn.SetParamFloat("cost", 12.5)
+	_ = conn.SetParamBool("returnMeta", true)
+	_ = conn.SetParamTime("logged", time.Date(2023, 11, 29, 14, 5, 6, 0, time.FixedZone("BST", 3600)))
+	_ = conn.SetParamBytes("content", []byte("hello"))
+	_ = conn.SetParamReader("file", strings.NewReader("hello"))
+	//-- Streamed params are only read at send time so GetParam shows them empty
+	want := "<params><rowstart>-5</rowstart><cost>12.5</cost><returnMeta>true</returnMeta>" +
+		"<logged>2023-11-29 13:05:06</logged><content>aGVsbG8=</content><file></file></params>"
+	if conn.GetParam() != want {
+		t.Errorf("Was expecting %s but got %s\n", want, conn.GetParam())
+	}
+	if _, err := conn.Invoke("session", "fileUpload"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "<content>aGVsbG8=</content><file>aGVsbG8=</file></params>") {
+		t.Errorf("Was expecting the reader param to be sent base64 encoded but got %s\n", got)
+	}
+}
+
+func TestSetParamSecret(t *testing.T) {
+	conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+	_ = conn.SetParam("userId", "admin")
+	_ = conn.SetParamSecret("password", "Password")
+	if conn.GetParam() != "<params><userId>admin</userId><password>********</password></params>" {
+		t.Errorf("Was expecting the password to be redacted but got %s\n", conn.GetParam())
+	}
+	if !strings.Contains(conn.paramsxml, "<password>UGFzc3dvcmQ=</password>") {
+		t.Errorf("Was expecting the password to be sent base64 encoded but got %s\n", conn.paramsxml)
+	}
+}
+
+func TestJSONRequest(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+		switch r.Header.Get("Content-Type") {
+		case "application/json":
+			fmt.Fprint(w, `{"@status":true,"params":{}}`)
+		case "text/xmlmc":
+			fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+		default:
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetJSONRequest(true)
+	conn.SetTrace("test")
+	_ = conn.SetParam("application", "com.hornbill.servicemanager")
+	_ = conn.OpenElement("filter")
+	_ = conn.SetParamAttr("column", "h_name", []ParamAttribStruct{{"op", "eq"}})
+	_ = conn.SetParam("value", "a")
+	_ = conn.SetParam("value", "b")
+	_ = conn.CloseElement("filter")
+	if _, err := conn.Invoke("data", "entityBrowseRecords2"); err != nil {
+		t.Errorf(err.Error())
+	}
+	want := `{"@service":"data","@method":"entityBrowseRecords2","@trace":"goApi/test","params":{"application":"com.hornbill.servicemanager",` +
+		`"filter":{"column":{"@op":"eq","#text":"h_name"},"value":["a","b"]}}}`
+	if got != want {
+		t.Errorf("Was expecting %s but got %s\n", want, got)
+	}
+
+	conn.SetJSONRequest(false)
+	_ = conn.SetParam("stage", "1")
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Errorf(err.Error())
+	}
+	if !strings.Contains(got, "<params><stage>1</stage></params>") {
+		t.Errorf("Was expecting an xml methodCall but got %s\n", got)
+	}
+
+	if _, err := buildJSONMethodCall("data", "entityAddRecord", "goApi", "<params><note>text<line>1</line></note></params>"); !errors.Is(err, ErrMixedContent) {
+		t.Errorf("Was expecting ErrMixedContent for text alongside child elements but got %v", err)
+	}
+	if _, err := buildJSONMethodCall("data", "entityAddRecord", "goApi", "<params>\n  <note>\n    <line>1</line>\n  </note>\n</params>"); err != nil {
+		t.Errorf("Was not expecting an error for indentation between elements but got %v", err)
+	}
+}
+
+func TestInvokeResponse(t *testing.T) {
+	var requestID, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("X-Request-Id")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("Set-Cookie", "ESPSESSION=abc; Path=/")
+		w.Header().Set("Content-Type", "text/json")
+		fmt.Fprint(w, `{"@status":true,"params":{"stageName":"XMLMC Session Bind","nextStage":4}}`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetTrace("importer")
+	_ = conn.SetParam("stage", "1")
+	response, err := conn.InvokeResponse("system", "pingCheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || response.Attempts != 1 || response.SessionID != "ESPSESSION=abc" {
+		t.Errorf("Unexpected response %+v\n", response)
+	}
+	if response.RequestID == "" || response.RequestID != requestID {
+		t.Errorf("Was expecting request id %s but got %s\n", requestID, response.RequestID)
+	}
+	if !strings.Contains(body, `trace="goApi/importer"`) {
+		t.Errorf("Was expecting the trace to be sent but got %s\n", body)
+	}
+	var result struct {
+		Status bool `json:"@status"`
+		Params struct {
+			NextStage int `json:"nextStage"`
+		} `json:"params"`
+	}
+	if err := response.Decode(&result); err != nil || !result.Status || result.Params.NextStage != 4 {
+		t.Errorf("Unexpected decoded result %+v %v\n", result, err)
+	}
+	if conn.GetParam() != "<params></params>" {
+		t.Errorf("Was expecting params to be cleared but got %s\n", conn.GetParam())
+	}
+}
+
+// countingReader records how many bytes have been read from it
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestSetParamReader(t *testing.T) {
+	var got string
+	var contentLength int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+		contentLength = r.ContentLength
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	content := strings.Repeat("0123456789", 100000) + "end"
+	file := &countingReader{r: strings.NewReader(content)}
+	conn := NewXmlmcInstance(ts.URL)
+	_ = conn.SetParam("folder", "/")
+	_ = conn.SetParamReader("content", file)
+	_ = conn.SetParamReader("small", strings.NewReader("hi"))
+	if file.read != 0 {
+		t.Errorf("Was expecting the reader not to be read before Invoke but %d bytes were read", file.read)
+	}
+	if _, err := conn.Invoke("session", "fileUpload"); err != nil {
+		t.Fatal(err)
+	}
+	want := "<params><folder>/</folder><content>" + base64.StdEncoding.EncodeToString([]byte(content)) +
+		"</content><small>aGk=</small></params></methodCall>"
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("Was expecting the streamed params to be base64 encoded in the body")
+	}
+	if contentLength != -1 {
+		t.Errorf("Was expecting a chunked request but got content length %d", contentLength)
+	}
+
+	conn.SetJSONRequest(true)
+	_ = conn.SetParamReader("content", strings.NewReader(content))
+	if _, err := conn.Invoke("session", "fileUpload"); err == nil {
+		t.Errorf("Was expecting an error streaming params as JSON")
+	}
+}
+
 func TestTimeout(t *testing.T) {
 	conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
-	if conn.timeout != 30 {
+	if conn.timeout != 30*time.Second {
 		t.Errorf("Was expecting timeout defualt of 30")
 	}
 	conn.SetTimeout(40)
-	if conn.timeout != 40 {
-		t.Errorf("Was expecting timeout of 40 but got%d\n", conn.timeout)
+	if conn.timeout != 40*time.Second {
+		t.Errorf("Was expecting timeout of 40 but got %s\n", conn.timeout)
+	}
+	conn.SetTimeoutDuration(1500 * time.Millisecond)
+	conn.SetMethodTimeout("reporting", "", time.Minute)
+	conn.SetMethodTimeout("reporting", "reportRun", 10*time.Minute)
+	if conn.callTimeout("system", "pingCheck") != 1500*time.Millisecond ||
+		conn.callTimeout("reporting", "reportRunGetStatus") != time.Minute ||
+		conn.callTimeout("reporting", "reportRun") != 10*time.Minute {
+		t.Errorf("Unexpected method timeouts")
 	}
 }
 
@@ -207,3 +530,58 @@ func TestInvokeXML(t *testing.T) {
 
 	}
 }
+
+var httpErrorTests = []struct {
+	status  int
+	body    string
+	message string
+	code    string
+}{
+	{500, `<?xml version="1.0" encoding="utf-8" ?><methodCallResult status="fail"><state><code>0200</code><service>session</service>` +
+		`<operation>userLogon</operation><error>The specified user is not valid</error></state></methodCallResult>`,
+		"Invalid HTTP Response: 500: The specified user is not valid", "0200"},
+	{401, `{"@status":false,"state":{"code":"0207","service":"system","operation":"pingCheck","error":"Session expired"}}`,
+		"Invalid HTTP Response: 401: Session expired", "0207"},
+	{503, `Service Unavailable`, "Invalid HTTP Response: 503", ""},
+}
+
+func TestHTTPError(t *testing.T) {
+	for _, tt := range httpErrorTests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		}))
+		conn := NewXmlmcInstance(ts.URL)
+		_, err := conn.Invoke("session", "userLogon")
+		ts.Close()
+
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			t.Fatalf("Was expecting an HTTPError but got %v", err)
+		}
+		if err.Error() != tt.message || httpErr.StatusCode != tt.status || string(httpErr.Body) != tt.body {
+			t.Errorf("for: %d got: %s %d %s", tt.status, err, httpErr.StatusCode, httpErr.Body)
+		}
+		if httpErr.Header.Get("Retry-After") != "10" {
+			t.Errorf("Was expecting the response headers to be kept")
+		}
+		if (tt.code == "") != (httpErr.MethodError == nil) || (tt.code != "" && httpErr.MethodError.Code != tt.code) {
+			t.Errorf("for: %d unexpected method error %+v", tt.status, httpErr.MethodError)
+		}
+	}
+}
+
+func TestHTTPErrorTruncated(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, strings.Repeat("x", maxErrorBodySize+10))
+	}))
+	defer ts.Close()
+
+	_, err := NewXmlmcInstance(ts.URL).Invoke("system", "pingCheck")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || len(httpErr.Body) != maxErrorBodySize || !httpErr.Truncated {
+		t.Errorf("Was expecting a truncated body")
+	}
+}
@@ -0,0 +1,119 @@
+package apiLib
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultAsyncConcurrency is the number of calls made with InvokeAsync that an instance sends at once
+const defaultAsyncConcurrency = 4
+
+// Future is the pending result of a call made with InvokeAsync
+type Future struct {
+	done     chan struct{}
+	response *Response
+	err      error
+}
+
+// Done returns a channel that is closed once the call has finished
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the call has finished or ctx is done, and returns the Response of the call.
+// As with InvokeResponse a method returning a status of fail is not an error, check Response.Err.
+// If ctx is done first its error is returned and the call carries on
+// response, err := future.Wait(ctx)
+func (f *Future) Wait(ctx context.Context) (*Response, error) {
+	//-- A finished call is returned even if ctx is also done
+	select {
+	case <-f.done:
+		return f.response, f.err
+	default:
+	}
+	select {
+	case <-f.done:
+		return f.response, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// complete sets the result of the future and releases anyone waiting on it
+func (f *Future) complete(response *Response, err error) *Future {
+	f.response, f.err = response, err
+	close(f.done)
+	return f
+}
+
+// asyncExecutor bounds the calls made with InvokeAsync that are sent at once
+type asyncExecutor struct {
+	mu    sync.Mutex
+	slots chan struct{}
+}
+
+// SetAsyncConcurrency sets how many calls made with InvokeAsync are sent at once, it defaults to 4.
+// Calls already waiting keep the limit they were made with
+// conn.SetAsyncConcurrency(8)
+func (xmlmc *XmlmcInstStruct) SetAsyncConcurrency(n int) {
+	if n <= 0 {
+		n = defaultAsyncConcurrency
+	}
+	xmlmc.async.mu.Lock()
+	defer xmlmc.async.mu.Unlock()
+	xmlmc.async.slots = make(chan struct{}, n)
+}
+
+// slotsFor returns the slots calls must take one of before being sent
+func (e *asyncExecutor) slotsFor() chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.slots == nil {
+		e.slots = make(chan struct{}, defaultAsyncConcurrency)
+	}
+	return e.slots
+}
+
+// InvokeAsync captures the params set so far as a call to servicename::methodname, clears them so the next
+// call can be built straight away, and sends the call in the background within the limit set with
+// SetAsyncConcurrency. Cancelling ctx abandons the call if it is still waiting or cancels the request.
+// The Response is collected from the Future returned, with Wait or WaitAll
+// future := conn.InvokeAsync(ctx, "data", "entityGetRecord")
+func (xmlmc *XmlmcInstStruct) InvokeAsync(ctx context.Context, servicename string, methodname string) *Future {
+	future := &Future{done: make(chan struct{})}
+	call, err := xmlmc.newMethodCall(servicename, methodname)
+	if err != nil {
+		return future.complete(nil, err)
+	}
+	call.ctx = ctx
+	xmlmc.ClearParam()
+
+	slots := xmlmc.async.slotsFor()
+	go func() {
+		if err := ctx.Err(); err != nil {
+			future.complete(nil, err)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			future.complete(nil, ctx.Err())
+			return
+		}
+		defer func() { <-slots }()
+		future.complete(xmlmc.invoke(call))
+	}()
+	return future
+}
+
+// WaitAll waits for every future and returns the responses and errors in the order the futures were given.
+// Futures not finished before ctx is done are given the error of ctx
+// responses, errs := apiLib.WaitAll(ctx, futures...)
+func WaitAll(ctx context.Context, futures ...*Future) ([]*Response, []error) {
+	responses := make([]*Response, len(futures))
+	errs := make([]error, len(futures))
+	for i, future := range futures {
+		responses[i], errs[i] = future.Wait(ctx)
+	}
+	return responses, errs
+}
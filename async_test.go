@@ -0,0 +1,109 @@
+package apiLib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInvokeAsync(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, `<methodCallResult status="ok"><params><echo>%s</echo></params></methodCallResult>`, extractValue(string(body)))
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	conn.SetAsyncConcurrency(2)
+	ctx := context.Background()
+	var futures []*Future
+	for i := 0; i < 6; i++ {
+		conn.SetParam("value", fmt.Sprint(i))
+		futures = append(futures, conn.InvokeAsync(ctx, "system", "echo"))
+	}
+	if conn.GetParam() != "<params></params>" {
+		t.Errorf("Was expecting the params to be cleared once captured %s", conn.GetParam())
+	}
+
+	responses, errs := WaitAll(ctx, futures...)
+	for i, response := range responses {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if expected := fmt.Sprintf("<echo>%d</echo>", i); !strings.Contains(response.String(), expected) {
+			t.Errorf("Was expecting response %d in order to hold %s got %s", i, expected, response.String())
+		}
+	}
+	if maxInFlight.Load() > 2 {
+		t.Errorf("Was expecting at most 2 calls at once got %d", maxInFlight.Load())
+	}
+	if conn.GetCount() != 6 {
+		t.Errorf("Was expecting 6 calls to be counted got %d", conn.GetCount())
+	}
+}
+
+func TestInvokeAsyncCancel(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	conn.SetAsyncConcurrency(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := conn.InvokeAsync(ctx, "system", "pingCheck")
+	waiting := conn.InvokeAsync(ctx, "system", "pingCheck")
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	_, errs := WaitAll(context.Background(), sent, waiting)
+	for i, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Was expecting call %d to be cancelled got %v", i, err)
+		}
+	}
+
+	//-- Malformed params fail straight away
+	conn.OpenElement("unclosed")
+	future := conn.InvokeAsync(context.Background(), "system", "pingCheck")
+	select {
+	case <-future.Done():
+	default:
+		t.Fatal("Was expecting the future to be done")
+	}
+	if _, err := future.Wait(context.Background()); !errors.Is(err, ErrUnbalancedElements) {
+		t.Errorf("Was expecting an unbalanced elements error got %v", err)
+	}
+}
+
+// extractValue returns the content of the value param of a methodCall
+func extractValue(body string) string {
+	start := strings.Index(body, "<value>")
+	end := strings.Index(body, "</value>")
+	if start == -1 || end == -1 {
+		return ""
+	}
+	return body[start+len("<value>") : end]
+}
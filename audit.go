@@ -0,0 +1,225 @@
+package apiLib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// The outcomes of a call in an AuditRecord
+const (
+	AuditOK     = "ok"
+	AuditFail   = "fail"
+	AuditError  = "error"
+	AuditStream = "streamed"
+)
+
+// AuditRecord is the record written to the audit sink for each call
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor,omitempty"`
+	Instance   string    `json:"instance"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	Service    string    `json:"service"`
+	Method     string    `json:"method"`
+	Params     string    `json:"params,omitempty"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Outcome    string    `json:"outcome"`
+	ErrorCode  string    `json:"errorCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	Attempts   int       `json:"attempts"`
+	UserAgent  string    `json:"userAgent"`
+	Trace      string    `json:"trace,omitempty"`
+	TraceID    string    `json:"traceId,omitempty"`
+	DryRun     bool      `json:"dryRun,omitempty"`
+}
+
+// auditSink is where the audit records of an instance are written
+type auditSink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	actor string
+}
+
+// SetAuditWriter writes a json line AuditRecord to w for every call made, with the values of sensitive params redacted.
+// Each record is written with a single Write so w can be shared between instances, nil stops the audit
+// conn.SetAuditWriter(auditFile)
+func (xmlmc *XmlmcInstStruct) SetAuditWriter(w io.Writer) {
+	xmlmc.audit.mu.Lock()
+	defer xmlmc.audit.mu.Unlock()
+	xmlmc.audit.w = w
+}
+
+// SetAuditActor sets who the calls are made on behalf of, recorded as the actor of each AuditRecord
+// conn.SetAuditActor("nightly-import")
+func (xmlmc *XmlmcInstStruct) SetAuditActor(actor string) {
+	xmlmc.audit.mu.Lock()
+	defer xmlmc.audit.mu.Unlock()
+	xmlmc.audit.actor = actor
+}
+
+// auditCall writes the AuditRecord of a call if an audit writer is set
+func (xmlmc *XmlmcInstStruct) auditCall(call *methodCall, response *Response, err error, start time.Time) {
+	xmlmc.audit.mu.Lock()
+	defer xmlmc.audit.mu.Unlock()
+	if xmlmc.audit.w == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:       start.UTC(),
+		Actor:      xmlmc.audit.actor,
+		Instance:   xmlmc.instanceID,
+		Service:    call.service,
+		Method:     call.method,
+		Params:     redactParams(call.paramsxml, xmlmc.isRedacted),
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		UserAgent:  xmlmc.userAgent,
+		Trace:      xmlmc.trace,
+	}
+	if record.Instance == "" {
+		record.Instance = xmlmc.server
+	}
+	switch {
+	case err != nil:
+		record.Outcome = AuditError
+		record.Error = err.Error()
+		record.StatusCode, _ = httpStatus(err)
+	case response.Body == nil && response.DryRun == nil:
+		record.Outcome = AuditStream
+	default:
+		record.Outcome = AuditOK
+		if methodErr := response.MethodError(); methodErr != nil {
+			record.Outcome = AuditFail
+			record.ErrorCode = methodErr.Code
+			record.Error = methodErr.Message
+		}
+	}
+	if response != nil {
+		record.Endpoint = response.Endpoint
+		record.StatusCode = response.StatusCode
+		record.Attempts = response.Attempts
+		record.TraceID = response.RequestID
+		record.DryRun = response.DryRun != nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println("Could not encode audit record:", err)
+		return
+	}
+	if _, err := xmlmc.audit.w.Write(append(line, '\n')); err != nil {
+		log.Println("Could not write audit record:", err)
+	}
+}
+
+// AuditFile is an io.WriteCloser for SetAuditWriter that appends to a file, rotating it once it reaches
+// its maximum size. It is safe to share between instances
+type AuditFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewAuditFile opens path for appending. Once a write would take the file past maxSize bytes it is renamed
+// to path.1, with older files moved up to path.maxBackups and the oldest removed. A maxSize of 0 never rotates
+// auditFile, err := apiLib.NewAuditFile("/var/log/xmlmc-audit.jsonl", 100<<20, 5)
+func NewAuditFile(path string, maxSize int64, maxBackups int) (*AuditFile, error) {
+	f := &AuditFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file, rotating first if p would take it past the maximum size
+func (f *AuditFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		//-- Keep writing to the reopened file if only the backups could not be moved
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			log.Println("Could not rotate audit file:", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file
+func (f *AuditFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the file for appending, f.mu must be held once the AuditFile is shared
+func (f *AuditFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the current file to the first backup and opens a new one, f.mu must be held.
+// The file is reopened even if the backups could not be moved so records are not lost
+func (f *AuditFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	err := f.moveBackups()
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// moveBackups moves each backup up one, removing the oldest, and the file to the first backup
+func (f *AuditFile) moveBackups() error {
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	os.Remove(f.backup(f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.backup(1))
+}
+
+// backup returns the path of the nth backup
+func (f *AuditFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
@@ -0,0 +1,98 @@
+package apiLib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditWriter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("method") == "userLogoff" {
+			fmt.Fprint(w, `<methodCallResult status="fail"><state><code>0200</code><error>Not logged on</error></state></methodCallResult>`)
+			return
+		}
+		if r.URL.Query().Get("method") == "getSystemInfo" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	var sink bytes.Buffer
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	conn.SetAuditWriter(&sink)
+	conn.SetAuditActor("import")
+	conn.SetTrace("audit")
+	conn.SetParam("userId", "admin")
+	conn.SetParamSecret("password", "Password1")
+	if _, err := conn.Invoke("session", "userLogon"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Invoke("session", "userLogoff")
+	conn.Invoke("system", "getSystemInfo")
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(&sink)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Was expecting a json line %s", scanner.Text())
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Was expecting a record per call got %d", len(records))
+	}
+	logon := records[0]
+	if logon.Outcome != AuditOK || logon.Service != "session" || logon.Method != "userLogon" || logon.Actor != "import" || logon.Trace != "audit" ||
+		logon.StatusCode != 200 || logon.TraceID == "" || logon.Attempts != 1 || logon.Endpoint != ts.URL+"/xmlmc/" || logon.UserAgent == "" {
+		t.Errorf("Unexpected record %+v", logon)
+	}
+	if logon.Params != "<userId>admin</userId><password>********</password>" {
+		t.Errorf("Was expecting the redacted params %s", logon.Params)
+	}
+	if records[1].Outcome != AuditFail || records[1].ErrorCode != "0200" || records[1].Error != "Not logged on" {
+		t.Errorf("Was expecting the method failure %+v", records[1])
+	}
+	if records[2].Outcome != AuditError || records[2].StatusCode != http.StatusServiceUnavailable || records[2].Error == "" {
+		t.Errorf("Was expecting the http error %+v", records[2])
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := NewAuditFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("Was expecting %s to hold %q got %q %v", name, content, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Was expecting only %d backups to be kept", 2)
+	}
+	if _, err := f.Write([]byte("closed")); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Was expecting writing to a closed file to fail %v", err)
+	}
+}
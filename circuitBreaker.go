@@ -0,0 +1,223 @@
+package apiLib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker of an endpoint
+type CircuitState int
+
+// The states of a circuit breaker, calls are sent while closed, fail fast while open
+// and a limited number are sent to test the endpoint while half open
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerSettings configure the circuit breaker set with SetCircuitBreaker
+type CircuitBreakerSettings struct {
+	//-- FailureThreshold is the number of failures in a row that opens the circuit, 0 turns the breaker off
+	FailureThreshold int
+	//-- Cooldown is how long the circuit stays open before calls are let through to test the endpoint, it defaults to 30 seconds
+	Cooldown time.Duration
+	//-- HalfOpenMaxCalls is the number of test calls let through at once while half open, it defaults to 1
+	HalfOpenMaxCalls int
+	//-- OnStateChange is called when the circuit of an endpoint changes state, it must not block
+	OnStateChange func(endpoint string, from CircuitState, to CircuitState)
+}
+
+// CircuitBreakerStats are the state and counters of the circuit breaker of an endpoint
+type CircuitBreakerStats struct {
+	Endpoint            string
+	State               CircuitState
+	ConsecutiveFailures int
+	Opened              uint64
+	Rejected            uint64
+	OpenedAt            time.Time
+}
+
+// CircuitOpenError is returned without making a request while the circuit of every endpoint is open.
+// It matches ErrCircuitOpen with errors.Is
+type CircuitOpenError struct {
+	Endpoint string
+	RetryAt  time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Circuit open for %s until %s", e.Endpoint, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is allows errors.Is to match ErrCircuitOpen
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// circuitBreakers hold the circuit of each endpoint of an instance
+type circuitBreakers struct {
+	mu       sync.Mutex
+	settings CircuitBreakerSettings
+	circuits map[string]*circuit
+}
+
+// circuit is the state of one endpoint
+type circuit struct {
+	stats    CircuitBreakerStats
+	inFlight int
+}
+
+// SetCircuitBreaker turns on a circuit breaker for each endpoint so calls fail fast with a CircuitOpenError
+// once an endpoint has failed FailureThreshold times in a row, rather than each waiting for the timeout.
+// Only failures that suggest the endpoint is unreachable count, not methods returning a status of fail.
+// Setting the breaker resets the state of every endpoint
+// conn.SetCircuitBreaker(apiLib.CircuitBreakerSettings{FailureThreshold: 5, Cooldown: time.Minute})
+func (xmlmc *XmlmcInstStruct) SetCircuitBreaker(settings CircuitBreakerSettings) {
+	if settings.Cooldown <= 0 {
+		settings.Cooldown = defaultFailoverCooldown
+	}
+	if settings.HalfOpenMaxCalls <= 0 {
+		settings.HalfOpenMaxCalls = 1
+	}
+	xmlmc.breakers.mu.Lock()
+	defer xmlmc.breakers.mu.Unlock()
+	xmlmc.breakers.settings = settings
+	xmlmc.breakers.circuits = make(map[string]*circuit)
+}
+
+// GetCircuitBreakerStats returns the state and counters of the circuit of each endpoint that has been called
+// for _, stats := range conn.GetCircuitBreakerStats() { fmt.Println(stats.Endpoint, stats.State) }
+func (xmlmc *XmlmcInstStruct) GetCircuitBreakerStats() []CircuitBreakerStats {
+	xmlmc.breakers.mu.Lock()
+	defer xmlmc.breakers.mu.Unlock()
+	stats := make([]CircuitBreakerStats, 0, len(xmlmc.breakers.circuits))
+	for _, c := range xmlmc.breakers.circuits {
+		s := c.stats
+		//-- An open circuit past its cooldown lets the next call through
+		if s.State == CircuitOpen && !time.Now().Before(s.OpenedAt.Add(xmlmc.breakers.settings.Cooldown)) {
+			s.State = CircuitHalfOpen
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Endpoint < stats[j].Endpoint })
+	return stats
+}
+
+// allow returns a CircuitOpenError if a call to the endpoint must fail fast, otherwise the call is counted
+// as in flight until record is called
+func (b *circuitBreakers) allow(endpoint string) error {
+	b.mu.Lock()
+	if b.settings.FailureThreshold <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	c := b.circuit(endpoint)
+	var changed func()
+	switch c.stats.State {
+	case CircuitOpen:
+		retryAt := c.stats.OpenedAt.Add(b.settings.Cooldown)
+		if time.Now().Before(retryAt) {
+			c.stats.Rejected++
+			b.mu.Unlock()
+			return &CircuitOpenError{Endpoint: endpoint, RetryAt: retryAt}
+		}
+		changed = b.setState(endpoint, c, CircuitHalfOpen)
+	case CircuitHalfOpen:
+		if c.inFlight >= b.settings.HalfOpenMaxCalls {
+			c.stats.Rejected++
+			b.mu.Unlock()
+			return &CircuitOpenError{Endpoint: endpoint, RetryAt: time.Now().Add(b.settings.Cooldown)}
+		}
+	}
+	c.inFlight++
+	b.mu.Unlock()
+	if changed != nil {
+		changed()
+	}
+	return nil
+}
+
+// record updates the circuit of the endpoint with the outcome of a call let through by allow
+func (b *circuitBreakers) record(endpoint string, err error) {
+	b.mu.Lock()
+	if b.settings.FailureThreshold <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	c := b.circuit(endpoint)
+	if c.inFlight > 0 {
+		c.inFlight--
+	}
+	//-- Any other error says nothing about the endpoint, calls the caller ended are released rather than recorded
+	var changed func()
+	_, answered := httpStatus(err)
+	switch {
+	case err == nil || (answered && !isEndpointFailure(err)):
+		c.stats.ConsecutiveFailures = 0
+		if c.stats.State != CircuitClosed {
+			changed = b.setState(endpoint, c, CircuitClosed)
+		}
+	case isEndpointFailure(err):
+		c.stats.ConsecutiveFailures++
+		if c.stats.State != CircuitOpen && (c.stats.State == CircuitHalfOpen || c.stats.ConsecutiveFailures >= b.settings.FailureThreshold) {
+			c.stats.Opened++
+			c.stats.OpenedAt = time.Now()
+			changed = b.setState(endpoint, c, CircuitOpen)
+		}
+	}
+	b.mu.Unlock()
+	if changed != nil {
+		changed()
+	}
+}
+
+// release ends a call let through by allow without recording an outcome, as when the caller
+// cancelled the call or its deadline passed. Only a timeout set on the instance or method counts as a failure
+func (b *circuitBreakers) release(endpoint string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.settings.FailureThreshold <= 0 {
+		return
+	}
+	if c := b.circuit(endpoint); c.inFlight > 0 {
+		c.inFlight--
+	}
+}
+
+// circuit returns the circuit of an endpoint, creating it closed, b.mu must be held
+func (b *circuitBreakers) circuit(endpoint string) *circuit {
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		c = &circuit{stats: CircuitBreakerStats{Endpoint: endpoint}}
+		b.circuits[endpoint] = c
+	}
+	return c
+}
+
+// setState changes the state of a circuit, b.mu must be held. It returns the callback to run once b.mu is released
+func (b *circuitBreakers) setState(endpoint string, c *circuit, to CircuitState) func() {
+	from := c.stats.State
+	c.stats.State = to
+	if b.settings.OnStateChange == nil {
+		return nil
+	}
+	onStateChange := b.settings.OnStateChange
+	return func() { onStateChange(endpoint, from, to) }
+}
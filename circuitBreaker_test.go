@@ -0,0 +1,159 @@
+package apiLib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="fail"><state><error>Record not found</error></state></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var changes []string
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetCircuitBreaker(CircuitBreakerSettings{
+		FailureThreshold: 2,
+		Cooldown:         100 * time.Millisecond,
+		OnStateChange: func(endpoint string, from CircuitState, to CircuitState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, from.String()+">"+to.String())
+		},
+	})
+
+	//-- Methods returning a status of fail do not count as failures
+	for i := 0; i < 3; i++ {
+		if _, err := conn.InvokeResponse("data", "entityGetRecord"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := conn.GetCircuitBreakerStats(); len(stats) != 1 || stats[0].State != CircuitClosed || stats[0].ConsecutiveFailures != 0 {
+		t.Errorf("Was expecting the circuit to be closed %+v", stats)
+	}
+
+	down.Store(true)
+	for i := 0; i < 2; i++ {
+		if _, err := conn.InvokeResponse("data", "entityGetRecord"); !errors.Is(err, ErrHTTPStatus) {
+			t.Errorf("Was expecting an http error got %v", err)
+		}
+	}
+	requests.Store(0)
+	_, err := conn.InvokeResponse("data", "entityGetRecord")
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) || !IsTransient(err) || openErr.Endpoint != ts.URL {
+		t.Errorf("Was expecting a CircuitOpenError got %v", err)
+	}
+	if requests.Load() != 0 {
+		t.Errorf("Was expecting no request while the circuit is open")
+	}
+	stats := conn.GetCircuitBreakerStats()
+	if stats[0].State != CircuitOpen || stats[0].Opened != 1 || stats[0].Rejected != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	//-- A failed test call while half open opens the circuit again
+	time.Sleep(150 * time.Millisecond)
+	if _, err := conn.InvokeResponse("data", "entityGetRecord"); !errors.Is(err, ErrHTTPStatus) {
+		t.Errorf("Was expecting the test call to be sent got %v", err)
+	}
+	if _, err := conn.InvokeResponse("data", "entityGetRecord"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Was expecting the circuit to open again got %v", err)
+	}
+
+	down.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	if _, err := conn.InvokeResponse("data", "entityGetRecord"); err != nil {
+		t.Errorf("Was expecting the circuit to close got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := "closed>open half-open>open half-open>closed"
+	got := ""
+	for _, change := range changes {
+		if change == "open>half-open" {
+			continue
+		}
+		got += change + " "
+	}
+	if got != expected+" " {
+		t.Errorf("Was expecting state changes %s got %v", expected, changes)
+	}
+}
+
+func TestCircuitBreakerCallerDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 1})
+
+	//-- The deadline of the caller passing does not count against the endpoint
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.InvokeAsync(ctx, "system", "pingCheck").Wait(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Was expecting the deadline of the caller got %v", err)
+	}
+	if stats := conn.GetCircuitBreakerStats(); len(stats) != 1 || stats[0].State != CircuitClosed || stats[0].ConsecutiveFailures != 0 {
+		t.Errorf("Was expecting the circuit to stay closed %+v", stats)
+	}
+
+	//-- The timeout of the instance does
+	conn.SetTimeoutDuration(50 * time.Millisecond)
+	if _, err := conn.InvokeResponse("system", "pingCheck"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Was expecting the instance timeout got %v", err)
+	}
+	if stats := conn.GetCircuitBreakerStats(); len(stats) != 1 || stats[0].State != CircuitOpen {
+		t.Errorf("Was expecting the circuit to open %+v", stats)
+	}
+}
+
+func TestCircuitBreakerFailover(t *testing.T) {
+	var secondary atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondary.Add(1)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer backup.Close()
+
+	conn := NewXmlmcInstance(primary.URL)
+	conn.SetEndpoints(primary.URL, backup.URL)
+	//-- Keep the primary preferred so only the breaker stops calls reaching it
+	conn.SetFailoverCooldown(time.Nanosecond)
+	conn.SetCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 1, Cooldown: time.Minute})
+
+	response, err := conn.InvokeResponse("system", "pingCheck")
+	if err != nil || response.Attempts != 2 {
+		t.Fatalf("Was expecting to fail over %v", err)
+	}
+	response, err = conn.InvokeResponse("session", "userLogon")
+	if err != nil || response.Attempts != 1 || response.Endpoint != backup.URL {
+		t.Errorf("Was expecting the open primary to be skipped %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/hornbill/goApiLib/cmd/xmlmc
+
+go 1.20
+
+require (
+	github.com/hornbill/goApiLib v1.4.0
+	github.com/hornbill/goApiLib/profiles v1.4.0
+	golang.org/x/term v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/BurntSushi/toml v1.4.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+)
+
+replace (
+	github.com/hornbill/goApiLib => ../..
+	github.com/hornbill/goApiLib/profiles => ../../profiles
+)
@@ -0,0 +1,210 @@
+// Command xmlmc invokes a Hornbill xmlmc service method and prints the response.
+//
+//	xmlmc -instance yourinstance -apikey-file ~/.hornbill/apikey -p userId=admin session getUserDetails
+//	xmlmc -profile prod -params query.yaml -output table data queryExec
+//	xmlmc -profile dev -shell
+//
+// Params are given as repeated -p key=value flags, where dots in the key create nested elements,
+// or read from an xml, json or yaml file with -params.
+//
+// The connection comes from a named profile in the config file, see profiles.LoadConfig, chosen with -profile,
+// HORNBILL_PROFILE or the default of the config. HORNBILL_INSTANCE, HORNBILL_APIKEY, HORNBILL_PROXY and
+// HORNBILL_TIMEOUT override the profile, and the -instance, -apikey, -apikey-file, -proxy and -timeout flags
+// override both.
+//
+// With -dry-run the requests are printed with the API key, session and secret params redacted rather than sent.
+// With -shell the params are built and methods invoked interactively on one connection, in a terminal
+// Tab completes commands, services and methods.
+//
+// Exit codes are 0 on success, 1 when the method returns a status of fail, 2 for usage errors
+// and 3 when the request could not be made or the server returned an http error.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	apiLib "github.com/hornbill/goApiLib"
+	"github.com/hornbill/goApiLib/profiles"
+)
+
+const (
+	exitOK = iota
+	exitMethodFailed
+	exitUsage
+	exitRequestFailed
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// options are the command line flags
+type options struct {
+	config      string
+	profile     string
+	instance    string
+	proxy       string
+	apiKey      string
+	apiKeyFile  string
+	paramsFile  string
+	params      paramFlags
+	output      string
+	timeout     time.Duration
+	jsonRequest bool
+	userAgent   string
+	shell       bool
+	dryRun      bool
+}
+
+// run executes the command with the environment read through getenv and returns the exit code
+func run(args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("xmlmc", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: xmlmc [flags] service method")
+		fmt.Fprintln(stderr, "       xmlmc [flags] -shell")
+		flags.PrintDefaults()
+	}
+	var opts options
+	flags.StringVar(&opts.config, "config", "", "config file of profiles, defaults to HORNBILL_CONFIG or the user config directory")
+	flags.StringVar(&opts.profile, "profile", "", "profile to use, defaults to HORNBILL_PROFILE or the default of the config")
+	flags.StringVar(&opts.instance, "instance", "", "instance name or xmlmc URL, overrides the profile and HORNBILL_INSTANCE")
+	flags.StringVar(&opts.apiKey, "apikey", "", "API key, overrides the profile and HORNBILL_APIKEY")
+	flags.StringVar(&opts.apiKeyFile, "apikey-file", "", "file containing the API key")
+	flags.StringVar(&opts.proxy, "proxy", "", "proxy URL, overrides the profile and HORNBILL_PROXY")
+	flags.StringVar(&opts.paramsFile, "params", "", "xml, json or yaml file of params")
+	flags.Var(&opts.params, "p", "param as key=value, may be repeated, dots in key create nested elements")
+	flags.StringVar(&opts.output, "output", "xml", "output format: xml, json or table")
+	flags.DurationVar(&opts.timeout, "timeout", 0, "request timeout, defaults to the profile or 30s")
+	flags.BoolVar(&opts.jsonRequest, "json-request", false, "send the request as a JSON methodCall")
+	flags.StringVar(&opts.userAgent, "user-agent", "", "user agent sent with the request")
+	flags.BoolVar(&opts.shell, "shell", false, "start an interactive shell")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "print the requests with secrets redacted rather than sending them")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if (opts.shell && flags.NArg() != 0) || (!opts.shell && flags.NArg() != 2) {
+		flags.Usage()
+		return exitUsage
+	}
+	if opts.output != "xml" && opts.output != "json" && opts.output != "table" {
+		fmt.Fprintln(stderr, "output must be xml, json or table")
+		return exitUsage
+	}
+	if opts.shell && opts.output == "table" {
+		fmt.Fprintln(stderr, "shell output must be xml or json")
+		return exitUsage
+	}
+	conn, code := connect(opts, getenv, stderr)
+	if conn == nil {
+		return code
+	}
+	conn.SetJSONRequest(opts.jsonRequest)
+	conn.SetJSONResponse(opts.output != "xml")
+	if opts.dryRun {
+		conn.SetDryRun(true)
+		conn.SetDryRunWriter(stdout)
+	}
+
+	var params []*param
+	if opts.paramsFile != "" {
+		var err error
+		if params, err = parseParamsFile(opts.paramsFile); err != nil {
+			fmt.Fprintln(stderr, "unable to read params:", err)
+			return exitUsage
+		}
+	}
+	params = append(params, parseKeyValues(opts.params)...)
+	if err := setParams(conn, params); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	if opts.shell {
+		return runShell(conn, stdin, stdout, stderr, opts.output == "json")
+	}
+	return invoke(conn, flags.Arg(0), flags.Arg(1), opts.output, stdout, stderr)
+}
+
+// invoke makes the call and prints the response
+func invoke(conn *apiLib.XmlmcInstStruct, service string, method string, output string, stdout io.Writer, stderr io.Writer) int {
+	response, err := conn.InvokeResponse(service, method)
+	if err != nil {
+		var httpErr *apiLib.HTTPError
+		if errors.As(err, &httpErr) && len(httpErr.Body) > 0 && httpErr.MethodError == nil {
+			fmt.Fprintln(stderr, strings.TrimSpace(string(httpErr.Body)))
+		}
+		fmt.Fprintln(stderr, err)
+		return exitRequestFailed
+	}
+	if response.DryRun != nil {
+		return exitOK
+	}
+	if methodErr := response.MethodError(); methodErr != nil {
+		fmt.Fprintln(stderr, methodErr)
+		return exitMethodFailed
+	}
+	if err := writeResponse(stdout, response.Body, output); err != nil {
+		fmt.Fprintln(stderr, "unable to format response:", err)
+		return exitRequestFailed
+	}
+	return exitOK
+}
+
+// connect builds the connection from the profile with the flags applied, returning the exit code on failure
+func connect(opts options, getenv func(string) string, stderr io.Writer) (*apiLib.XmlmcInstStruct, int) {
+	var cfg *profiles.Config
+	var err error
+	if opts.config != "" {
+		cfg, err = profiles.LoadConfig(opts.config)
+	} else {
+		cfg, err = profiles.LoadDefaultConfig(getenv)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return nil, exitUsage
+	}
+	cfg.SetGetenv(getenv)
+	profile, err := cfg.Profile(opts.profile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return nil, exitUsage
+	}
+	if opts.instance != "" {
+		profile.Instance = opts.instance
+	}
+	if opts.apiKey != "" {
+		profile.APIKey = opts.apiKey
+	}
+	if opts.apiKeyFile != "" {
+		profile.APIKey = ""
+		profile.APIKeyFile = opts.apiKeyFile
+	}
+	if opts.proxy != "" {
+		profile.Proxy = opts.proxy
+	}
+	if opts.timeout > 0 {
+		profile.Timeout = opts.timeout.String()
+	}
+	if opts.userAgent != "" {
+		profile.UserAgent = opts.userAgent
+	} else if profile.UserAgent == "" {
+		profile.UserAgent = "xmlmc command line"
+	}
+
+	conn, err := profile.NewInstance()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		if errors.Is(err, apiLib.ErrConfig) {
+			return nil, exitUsage
+		}
+		return nil, exitRequestFailed
+	}
+	return conn, exitOK
+}
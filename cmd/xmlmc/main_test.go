@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiLib "github.com/hornbill/goApiLib"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	params := parseKeyValues([]string{"application=com.hornbill.servicemanager", "filter.column=h_name", "filter.value=a=b", "filter.column=h_id"})
+	if len(params) != 2 || params[1].name != "filter" || len(params[1].children) != 3 {
+		t.Fatalf("unexpected params %+v", params)
+	}
+	if params[1].children[1].value != "a=b" {
+		t.Errorf("expected value a=b got %s", params[1].children[1].value)
+	}
+}
+
+func TestParseParamsFiles(t *testing.T) {
+	expected := `<application>com.hornbill.servicemanager</application><queryParams><rowstart>0</rowstart><limit>10</limit></queryParams><queryParams><rowstart>10</rowstart></queryParams><flag type="bool">true</flag>`
+	tests := map[string]string{
+		"params.xml": `<params>
+	<application>com.hornbill.servicemanager</application>
+	<queryParams><rowstart>0</rowstart><limit>10</limit></queryParams>
+	<queryParams><rowstart>10</rowstart></queryParams>
+	<flag type="bool">true</flag>
+</params>`,
+		"params.json": `{"application":"com.hornbill.servicemanager","queryParams":[{"rowstart":0,"limit":10},{"rowstart":10}],"flag":{"@type":"bool","#text":true}}`,
+		"params.yaml": `params:
+  application: com.hornbill.servicemanager
+  queryParams:
+    - rowstart: 0
+      limit: 10
+    - rowstart: 10
+  flag:
+    "@type": bool
+    "#text": true
+`,
+	}
+	dir := t.TempDir()
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			params, err := parseParamsFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := apiLib.NewXmlmcInstance("http://127.0.0.1/xmlmc/")
+			if err := setParams(conn, params); err != nil {
+				t.Fatal(err)
+			}
+			if got := conn.GetParam(); got != "<params>"+expected+"</params>" {
+				t.Errorf("expected %s got %s", expected, got)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	var requestBody, requestAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requestBody = string(body)
+		requestAuth = r.Header.Get("Authorization")
+		if strings.Contains(requestBody, "fail") {
+			w.Write([]byte(`{"@status":false,"state":{"code":"0200","error":"it failed"}}`))
+			return
+		}
+		w.Write([]byte(`{"@status":true,"params":{"rowData":{"row":[{"h_id":"1","h_name":"one"},{"h_id":"2","h_name":"two"}]}}}`))
+	}))
+	defer ts.Close()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	env := map[string]string{"HORNBILL_APIKEY": "envkey"}
+	getenv := func(key string) string { return env[key] }
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-instance", ts.URL + "/", "-output", "table", "-p", "filter.column=h_name", "data", "queryExec"}, getenv, nil, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit %d got %d: %s", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(requestBody, "<filter><column>h_name</column></filter>") {
+		t.Errorf("params not sent %s", requestBody)
+	}
+	if requestAuth != "ESP-APIKEY envkey" {
+		t.Errorf("expected the API key from getenv got %q", requestAuth)
+	}
+	if !strings.Contains(stdout.String(), "h_id  h_name") || !strings.Contains(stdout.String(), "2     two") {
+		t.Errorf("unexpected table\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	code = run([]string{"-instance", ts.URL + "/", "-output", "json", "-p", "mode=fail", "data", "queryExec"}, getenv, nil, &stdout, &stderr)
+	if code != exitMethodFailed {
+		t.Errorf("expected exit %d got %d", exitMethodFailed, code)
+	}
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("profiles:\n  test:\n    instance: "+ts.URL+"/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	code = run([]string{"-config", config, "-profile", "test", "system", "pingCheck"}, getenv, nil, &stdout, &stderr)
+	if code != exitOK || !strings.Contains(requestBody, `service="system"`) {
+		t.Errorf("expected the profile to be used, exit %d request %s", code, requestBody)
+	}
+	code = run([]string{"-config", config, "-profile", "missing", "system", "pingCheck"}, getenv, nil, &stdout, &stderr)
+	if code != exitUsage {
+		t.Errorf("expected exit %d for a missing profile got %d", exitUsage, code)
+	}
+
+	stdout.Reset()
+	requestBody = ""
+	code = run([]string{"-instance", ts.URL + "/", "-apikey", "secretkey", "-dry-run", "-p", "mode=fail", "data", "queryExec"}, getenv, nil, &stdout, &stderr)
+	if code != exitOK || requestBody != "" || !strings.Contains(stdout.String(), "<mode>fail</mode>") || strings.Contains(stdout.String(), "secretkey") {
+		t.Errorf("Unexpected dry run exit %d output %s", code, stdout.String())
+	}
+
+	code = run([]string{"-instance", ts.URL + "/", "data"}, getenv, nil, &stdout, &stderr)
+	if code != exitUsage {
+		t.Errorf("expected exit %d got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	apiLib "github.com/hornbill/goApiLib"
+)
+
+// writeResponse prints the response body in the requested format
+func writeResponse(w io.Writer, body []byte, output string) error {
+	switch output {
+	case "json":
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err != nil {
+			return err
+		}
+		indented.WriteByte('\n')
+		_, err := indented.WriteTo(w)
+		return err
+	case "table":
+		return writeTable(w, body)
+	}
+	_, err := fmt.Fprintln(w, strings.TrimSpace(string(body)))
+	return err
+}
+
+// writeTable prints the rows of a result set as columns, or the params as name value pairs
+// when the response has no rows
+func writeTable(w io.Writer, body []byte) error {
+	rows, err := readRows(body)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(rows) == 0 {
+		var result struct {
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return err
+		}
+		for _, name := range sortedKeys(result.Params) {
+			value := result.Params[name]
+			if s, ok := value.(string); ok {
+				fmt.Fprintf(tw, "%s\t%s\n", name, s)
+				continue
+			}
+			encoded, _ := json.Marshal(value)
+			fmt.Fprintf(tw, "%s\t%s\n", name, encoded)
+		}
+		return tw.Flush()
+	}
+
+	columnSet := make(map[string]interface{})
+	for _, row := range rows {
+		for column := range row {
+			columnSet[column] = nil
+		}
+	}
+	columns := sortedKeys(columnSet)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = strings.ReplaceAll(row[column], "\n", " ")
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
+
+func readRows(body []byte) ([]apiLib.Row, error) {
+	var rows []apiLib.Row
+	decoder := apiLib.NewRowDecoder(bytes.NewReader(body))
+	for {
+		row, err := decoder.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
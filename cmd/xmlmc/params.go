@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apiLib "github.com/hornbill/goApiLib"
+	"gopkg.in/yaml.v3"
+)
+
+// param is a single xmlmc parameter, either a value or an element containing other params
+type param struct {
+	name     string
+	value    string
+	attribs  []apiLib.ParamAttribStruct
+	children []*param
+}
+
+// paramFlags collects repeated -p key=value flags, dots in the key create nested elements
+type paramFlags []string
+
+func (p *paramFlags) String() string {
+	return strings.Join(*p, " ")
+}
+
+func (p *paramFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("param %q must be in the form key=value", value)
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// parseKeyValues turns key=value pairs into params, consecutive keys sharing a prefix such as
+// filter.column=h_name filter.value=1 are set within the same element
+func parseKeyValues(pairs []string) []*param {
+	var params []*param
+	for _, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		path := strings.Split(key, ".")
+		siblings := &params
+		for _, name := range path[:len(path)-1] {
+			var element *param
+			if n := len(*siblings); n > 0 && (*siblings)[n-1].name == name && (*siblings)[n-1].children != nil {
+				element = (*siblings)[n-1]
+			} else {
+				element = &param{name: name, children: []*param{}}
+				*siblings = append(*siblings, element)
+			}
+			siblings = &element.children
+		}
+		*siblings = append(*siblings, &param{name: path[len(path)-1], value: value})
+	}
+	return params
+}
+
+// parseParamsFile reads params from an xml, json or yaml file depending on its extension
+func parseParamsFile(path string) ([]*param, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		return parseXMLParams(data)
+	case ".json":
+		return parseJSONParams(data)
+	case ".yaml", ".yml":
+		return parseYAMLParams(data)
+	}
+	return nil, fmt.Errorf("params file %s must be .xml, .json, .yaml or .yml", path)
+}
+
+// parseXMLParams reads xml params, either the children of a <params> element or a list of elements
+func parseXMLParams(data []byte) ([]*param, error) {
+	root := &param{children: []*param{}}
+	stack := []*param{root}
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &param{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				element.attribs = append(element.attribs, apiLib.ParamAttribStruct{Name: attr.Name.Local, Value: attr.Value})
+			}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, element)
+			stack = append(stack, element)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			stack[len(stack)-1].value += string(t)
+		}
+	}
+	params := root.children
+	if len(params) == 1 && params[0].name == "params" {
+		params = params[0].children
+	}
+	trimContainers(params)
+	return params, nil
+}
+
+// trimContainers clears the whitespace between the child elements of elements
+func trimContainers(params []*param) {
+	for _, p := range params {
+		if len(p.children) > 0 {
+			p.value = ""
+			trimContainers(p.children)
+		}
+	}
+}
+
+// parseJSONParams reads params from a json object keeping the order of its members.
+// Arrays become repeated elements, "@name" members become attributes and "#text" the element value
+func parseJSONParams(data []byte) ([]*param, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, errors.New("json params must be an object")
+	}
+	root := &param{}
+	if err := readJSONObject(decoder, root); err != nil {
+		return nil, err
+	}
+	if len(root.children) == 1 && root.children[0].name == "params" {
+		return root.children[0].children, nil
+	}
+	return root.children, nil
+}
+
+// readJSONObject reads the members of an object whose opening { has been read into element
+func readJSONObject(decoder *json.Decoder, element *param) error {
+	element.children = []*param{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		name := token.(string)
+		if err := readJSONValue(decoder, element, name); err != nil {
+			return err
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+// readJSONValue reads the value of member name and adds it to parent
+func readJSONValue(decoder *json.Decoder, parent *param, name string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '[' {
+			for decoder.More() {
+				if err := readJSONValue(decoder, parent, name); err != nil {
+					return err
+				}
+			}
+			_, err := decoder.Token()
+			return err
+		}
+		child := &param{name: name}
+		parent.children = append(parent.children, child)
+		return readJSONObject(decoder, child)
+	}
+	value := scalarString(token)
+	switch {
+	case strings.HasPrefix(name, "@"):
+		parent.attribs = append(parent.attribs, apiLib.ParamAttribStruct{Name: name[1:], Value: value})
+	case name == "#text":
+		parent.value = value
+	default:
+		parent.children = append(parent.children, &param{name: name, value: value})
+	}
+	return nil
+}
+
+// parseYAMLParams reads params from a yaml mapping with the same rules as json
+func parseYAMLParams(data []byte) ([]*param, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	if document.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("yaml params must be a mapping")
+	}
+	root := &param{}
+	readYAMLMapping(document.Content[0], root)
+	if len(root.children) == 1 && root.children[0].name == "params" {
+		return root.children[0].children, nil
+	}
+	return root.children, nil
+}
+
+func readYAMLMapping(node *yaml.Node, element *param) {
+	element.children = []*param{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		readYAMLValue(node.Content[i+1], element, node.Content[i].Value)
+	}
+}
+
+func readYAMLValue(node *yaml.Node, parent *param, name string) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			readYAMLValue(item, parent, name)
+		}
+		return
+	case yaml.MappingNode:
+		child := &param{name: name}
+		parent.children = append(parent.children, child)
+		readYAMLMapping(node, child)
+		return
+	case yaml.AliasNode:
+		readYAMLValue(node.Alias, parent, name)
+		return
+	}
+	value := node.Value
+	if node.Tag == "!!null" {
+		value = ""
+	}
+	switch {
+	case strings.HasPrefix(name, "@"):
+		parent.attribs = append(parent.attribs, apiLib.ParamAttribStruct{Name: name[1:], Value: value})
+	case name == "#text":
+		parent.value = value
+	default:
+		parent.children = append(parent.children, &param{name: name, value: value})
+	}
+}
+
+func scalarString(token json.Token) string {
+	switch v := token.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprint(token)
+}
+
+// setParams adds the params to the connection using SetParam, OpenElement and CloseElement
+func setParams(conn *apiLib.XmlmcInstStruct, params []*param) error {
+	for _, p := range params {
+		var err error
+		switch {
+		case len(p.children) > 0:
+			if err = conn.OpenElementAttr(p.name, p.attribs); err == nil {
+				if err = setParams(conn, p.children); err == nil {
+					err = conn.CloseElement(p.name)
+				}
+			}
+		case len(p.attribs) > 0:
+			err = conn.SetParamAttr(p.name, p.value, p.attribs)
+		default:
+			err = conn.SetParam(p.name, p.value)
+		}
+		if err != nil {
+			return fmt.Errorf("param %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	apiLib "github.com/hornbill/goApiLib"
+)
+
+// knownMethods seeds tab completion and the complete command, methods invoked during the session are added to it
+var knownMethods = map[string][]string{
+	"session": {"userLogon", "userLogoff", "getSessionInfo", "getUserDetails", "analystLogon", "bindSession", "isSessionValid"},
+	"system":  {"pingCheck", "getSystemInfo", "getServerInfo", "getTimeZoneList", "logMessage"},
+	"data":    {"entityAddRecord", "entityBrowseRecords2", "entityDeleteRecord", "entityGetRecord", "entityUpdateRecord", "queryExec", "sqlQuery"},
+	"admin":   {"userCreate", "userDelete", "userGetInfo", "userUpdate", "userAddRole", "userSetStatus", "getInstanceInfo"},
+	"library": {"userGetRights"},
+	"mail":    {"sendMessage", "getMailboxList"},
+}
+
+// shellCommands are the commands understood by the shell with their usage
+var shellCommands = map[string]string{
+	"set":      "set name value       add a param to the current element",
+	"open":     "open name            open an element, params are set within it until close",
+	"close":    "close [name]         close the current element",
+	"params":   "params               show the params that will be sent",
+	"clear":    "clear                clear the params",
+	"invoke":   "invoke service method  invoke a method with the params, then clear them",
+	"logon":    "logon userId         log on with session::userLogon, prompting for the password, and keep the session",
+	"json":     "json on|off          toggle json responses",
+	"history":  "history              list previous commands, !n runs command n again",
+	"complete": "complete [line]      list the words that can complete the last word of line",
+	"help":     "help                 show this help",
+	"exit":     "exit                 leave the shell",
+}
+
+// lineReader reads the lines typed into the shell
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	ReadPassword(prompt string) (string, error)
+}
+
+// scanReader reads lines from input that is not a terminal, such as a pipe or a script
+type scanReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func newScanReader(in io.Reader, out io.Writer) *scanReader {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &scanReader{scanner: scanner, out: out}
+}
+
+func (r *scanReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// ReadPassword reads the password as a line, input that is not a terminal is not shown as it is typed
+func (r *scanReader) ReadPassword(prompt string) (string, error) {
+	line, err := r.ReadLine(prompt)
+	if err == nil {
+		fmt.Fprintln(r.out)
+	}
+	return line, err
+}
+
+// shell is an interactive session on a single connection
+type shell struct {
+	conn     *apiLib.XmlmcInstStruct
+	reader   lineReader
+	out      io.Writer
+	errOut   io.Writer
+	elements []string
+	history  []string
+	json     bool
+	methods  map[string][]string
+}
+
+// runShell reads commands from in until exit or end of input. When in is a terminal it is put in raw mode
+// so Tab completes the command, service or method being typed and passwords are read without echo
+func runShell(conn *apiLib.XmlmcInstStruct, in io.Reader, out io.Writer, errOut io.Writer, json bool) int {
+	s := &shell{conn: conn, out: out, errOut: errOut, json: json, methods: make(map[string][]string)}
+	for service, methods := range knownMethods {
+		s.methods[service] = append([]string(nil), methods...)
+	}
+	conn.SetJSONResponse(json)
+
+	s.reader = newScanReader(in, out)
+	if f, ok := in.(*os.File); ok {
+		terminal, restore, err := newTerminalReader(f, out, s.completeAt)
+		if err != nil {
+			fmt.Fprintln(errOut, "unable to use the terminal, tab completion is off:", err)
+		} else if terminal != nil {
+			defer restore()
+			s.reader, s.out, s.errOut = terminal, terminal, terminal
+		}
+	}
+
+	if _, ok := s.reader.(*terminalReader); ok {
+		fmt.Fprintln(s.out, "xmlmc shell, type help for commands, press Tab to complete a command, service or method")
+	} else {
+		fmt.Fprintln(s.out, "xmlmc shell, type help for commands and complete to list completions")
+	}
+	for {
+		line, err := s.reader.ReadLine(s.prompt())
+		if err != nil {
+			fmt.Fprintln(s.out)
+			return exitOK
+		}
+		line = strings.TrimLeft(line, " \t")
+		//-- The rest of the line is completed as typed, a trailing space asks for the next word
+		if line == "complete" || strings.HasPrefix(line, "complete ") {
+			s.printCompletions(strings.TrimPrefix(strings.TrimPrefix(line, "complete"), " "))
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 1 || n > len(s.history) {
+				fmt.Fprintln(s.errOut, "no command", line)
+				continue
+			}
+			line = s.history[n-1]
+			fmt.Fprintln(s.out, line)
+		}
+		s.history = append(s.history, line)
+		if s.execute(line) {
+			return exitOK
+		}
+	}
+}
+
+// prompt shows the open elements so the user knows where the next param will be set
+func (s *shell) prompt() string {
+	if len(s.elements) == 0 {
+		return "xmlmc> "
+	}
+	return "xmlmc/" + strings.Join(s.elements, "/") + "> "
+}
+
+// execute runs a single command and returns true when the shell should exit
+func (s *shell) execute(line string) bool {
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
+	switch command {
+	case "set":
+		if len(args) < 1 {
+			s.usage(command)
+			return false
+		}
+		//-- The value is the rest of the line so it can contain spaces
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[len(command):]), args[0]))
+		s.report(s.conn.SetParam(args[0], value))
+	case "open":
+		if len(args) != 1 {
+			s.usage(command)
+			return false
+		}
+		if err := s.conn.OpenElement(args[0]); err != nil {
+			s.report(err)
+			return false
+		}
+		s.elements = append(s.elements, args[0])
+	case "close":
+		if len(s.elements) == 0 {
+			fmt.Fprintln(s.errOut, "no element is open")
+			return false
+		}
+		name := s.elements[len(s.elements)-1]
+		if len(args) > 0 {
+			name = args[0]
+		}
+		if err := s.conn.CloseElement(name); err != nil {
+			s.report(err)
+			return false
+		}
+		s.elements = s.elements[:len(s.elements)-1]
+	case "params":
+		fmt.Fprintln(s.out, s.conn.GetParam())
+	case "clear":
+		s.conn.ClearParam()
+		s.elements = nil
+	case "invoke", "call":
+		if len(args) != 2 {
+			s.usage("invoke")
+			return false
+		}
+		s.invoke(args[0], args[1])
+	case "logon":
+		//-- The password is prompted for so it is neither shown nor kept in the history
+		if len(args) != 1 {
+			s.usage(command)
+			return false
+		}
+		password, err := s.reader.ReadPassword("password: ")
+		if err != nil {
+			s.report(err)
+			return false
+		}
+		s.conn.ClearParam()
+		s.elements = nil
+		s.conn.SetParam("userId", args[0])
+		s.conn.SetParamSecret("password", password)
+		if s.invoke("session", "userLogon") {
+			fmt.Fprintln(s.out, "session", s.conn.GetSessionID())
+		}
+	case "json":
+		switch {
+		case len(args) == 0:
+			s.json = !s.json
+		case args[0] == "on":
+			s.json = true
+		case args[0] == "off":
+			s.json = false
+		default:
+			s.usage(command)
+			return false
+		}
+		s.conn.SetJSONResponse(s.json)
+		if s.json {
+			fmt.Fprintln(s.out, "json responses on")
+		} else {
+			fmt.Fprintln(s.out, "json responses off")
+		}
+	case "history":
+		for i, entry := range s.history {
+			fmt.Fprintf(s.out, "%4d  %s\n", i+1, entry)
+		}
+	case "help":
+		for _, name := range sortedCommands() {
+			fmt.Fprintln(s.out, "  "+shellCommands[name])
+		}
+	case "exit", "quit":
+		return true
+	default:
+		fmt.Fprintf(s.errOut, "unknown command %s, type help for commands\n", command)
+	}
+	return false
+}
+
+// invoke calls the method and prints the response, returning true if the method succeeded
+func (s *shell) invoke(service string, method string) bool {
+	if len(s.elements) > 0 {
+		fmt.Fprintf(s.errOut, "close %s before invoking\n", strings.Join(s.elements, ", "))
+		return false
+	}
+	response, err := s.conn.InvokeResponse(service, method)
+	if err != nil {
+		s.report(err)
+		return false
+	}
+	s.remember(service, method)
+	output := "xml"
+	if s.json {
+		output = "json"
+	}
+	if err := writeResponse(s.out, response.Body, output); err != nil {
+		fmt.Fprintln(s.out, string(response.Body))
+	}
+	fmt.Fprintf(s.out, "(%s, %s)\n", response.Duration.Round(time.Millisecond), response.RequestID)
+	if methodErr := response.MethodError(); methodErr != nil {
+		s.report(methodErr)
+		return false
+	}
+	return true
+}
+
+// remember adds a method to the completions once it has been invoked
+func (s *shell) remember(service string, method string) {
+	for _, known := range s.methods[service] {
+		if known == method {
+			return
+		}
+	}
+	s.methods[service] = append(s.methods[service], method)
+}
+
+// completions returns the words that can complete the last word of line
+func (s *shell) completions(line string) []string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasSuffix(line, " ") {
+		fields = append(fields, "")
+	}
+	word := fields[len(fields)-1]
+	var candidates []string
+	switch {
+	case len(fields) == 1:
+		candidates = sortedCommands()
+	case fields[0] == "invoke" || fields[0] == "call":
+		if len(fields) == 2 {
+			for service := range s.methods {
+				candidates = append(candidates, service)
+			}
+		} else if len(fields) == 3 {
+			candidates = append(candidates, s.methods[fields[1]]...)
+		}
+	case fields[0] == "close" && len(fields) == 2 && len(s.elements) > 0:
+		candidates = []string{s.elements[len(s.elements)-1]}
+	case fields[0] == "json" && len(fields) == 2:
+		candidates = []string{"on", "off"}
+	}
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// completeAt completes the word before pos in line as Tab does, returning the new line and position.
+// When the word can not be extended the possible words are returned so they can be listed
+func (s *shell) completeAt(line string, pos int) (string, int, []string) {
+	head, tail := line[:pos], line[pos:]
+	matches := s.completions(head)
+	if len(matches) == 0 {
+		return line, pos, nil
+	}
+	word := head[strings.LastIndexAny(head, " \t")+1:]
+	completed := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, completed) {
+			completed = completed[:len(completed)-1]
+		}
+	}
+	if len(matches) == 1 {
+		completed += " "
+	} else if completed == word {
+		return line, pos, matches
+	}
+	head = head[:len(head)-len(word)] + completed
+	return head + tail, len(head), nil
+}
+
+func (s *shell) printCompletions(line string) {
+	matches := s.completions(line)
+	if len(matches) == 0 {
+		fmt.Fprintln(s.out, "no completions")
+		return
+	}
+	fmt.Fprintln(s.out, strings.Join(matches, "  "))
+}
+
+func (s *shell) usage(command string) {
+	fmt.Fprintln(s.errOut, "usage:", shellCommands[command])
+}
+
+func (s *shell) report(err error) {
+	if err != nil {
+		fmt.Fprintln(s.errOut, err)
+	}
+}
+
+func sortedCommands() []string {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
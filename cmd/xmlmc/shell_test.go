@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		if strings.Contains(string(body), "userLogon") {
+			http.SetCookie(w, &http.Cookie{Name: "ESPSessionState", Value: "abc123"})
+		}
+		w.Write([]byte(`{"@status":true,"params":{"ok":"yes"}}`))
+	}))
+	defer ts.Close()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	input := strings.Join([]string{
+		"open filter",
+		"set column h_name",
+		"set value some value",
+		"invoke data queryExec",
+		"close",
+		"params",
+		"invoke data queryExec",
+		"logon admin",
+		"secret",
+		"history",
+		"!4",
+		"!8",
+		"again",
+		"complete invoke data q",
+		"exit",
+	}, "\n")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-instance", ts.URL + "/", "-apikey", "key", "-output", "json", "-shell"}, func(string) string { return "" }, strings.NewReader(input), &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit %d got %d: %s", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "close filter before invoking") {
+		t.Errorf("expected invoke with an open element to fail, got %s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "<params><filter><column>h_name</column><value>some value</value></filter></params>") {
+		t.Errorf("params preview missing from\n%s", stdout.String())
+	}
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 requests got %d", len(requests))
+	}
+	if !strings.Contains(requests[0], "<value>some value</value>") || strings.Contains(requests[1], "filter") {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if !strings.Contains(stdout.String(), "session ESPSessionState=abc123") {
+		t.Errorf("expected the logon session in\n%s", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "secret") || !strings.Contains(stdout.String(), "   8  logon admin\n") {
+		t.Errorf("password shown in history\n%s", stdout.String())
+	}
+	//-- Repeating a logon prompts for the password again
+	if !strings.Contains(requests[3], "userLogon") || !strings.Contains(requests[3], "<password>YWdhaW4=</password>") {
+		t.Errorf("expected the logon to be repeated with the new password, got %s", requests[3])
+	}
+	if !strings.Contains(stdout.String(), "> queryExec\n") {
+		t.Errorf("expected completion of queryExec in\n%s", stdout.String())
+	}
+}
+
+func TestShellCompletions(t *testing.T) {
+	s := &shell{methods: map[string][]string{"session": {"userLogon", "userLogoff"}, "system": {"pingCheck"}}, elements: []string{"filter"}}
+	tests := map[string]string{
+		"":                       "clear close complete exit help history invoke json logon open params set",
+		"he":                     "help",
+		"invoke s":               "session system",
+		"invoke session userLog": "userLogoff userLogon",
+		"close ":                 "filter",
+		"json o":                 "off on",
+		"set x":                  "",
+	}
+	for line, expected := range tests {
+		if got := strings.Join(s.completions(line), " "); got != expected {
+			t.Errorf("%q expected %q got %q", line, expected, got)
+		}
+	}
+}
+
+func TestShellCompleteAt(t *testing.T) {
+	s := &shell{methods: map[string][]string{"session": {"userLogon", "userLogoff"}, "system": {"pingCheck"}}}
+	tests := []struct {
+		line, expected string
+		pos            int
+		matches        string
+	}{
+		{"inv", "invoke ", 7, ""},
+		{"invoke s", "invoke s", 8, "session system"},
+		{"invoke sy", "invoke system ", 14, ""},
+		{"invoke session user", "invoke session userLogo", 23, ""},
+		{"invoke session userLogo", "invoke session userLogo", 23, "userLogoff userLogon"},
+		{"set x", "set x", 5, ""},
+	}
+	for _, tt := range tests {
+		line, pos, matches := s.completeAt(tt.line, len(tt.line))
+		if line != tt.expected || pos != tt.pos || strings.Join(matches, " ") != tt.matches {
+			t.Errorf("%q expected %q %d %q got %q %d %q", tt.line, tt.expected, tt.pos, tt.matches, line, pos, matches)
+		}
+	}
+	//-- Completing in the middle of the line keeps the rest of it
+	if line, pos, _ := s.completeAt("invoke sy pingCheck", 9); line != "invoke system  pingCheck" || pos != 14 {
+		t.Errorf("unexpected completion %q %d", line, pos)
+	}
+}
+
+func TestTerminalTabCompletion(t *testing.T) {
+	s := &shell{methods: map[string][]string{"session": {"userLogon"}, "system": {"pingCheck"}}}
+	var out bytes.Buffer
+	r := newTerminal(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader("inv\tsy\tp\t\rinvoke s\t\rlogon admin\rsecret\r"), &out}, s.completeAt)
+
+	for _, expected := range []string{"invoke system pingCheck ", "invoke s", "logon admin"} {
+		if line, err := r.ReadLine("xmlmc> "); err != nil || line != expected {
+			t.Errorf("expected %q got %q %v", expected, line, err)
+		}
+	}
+	if !strings.Contains(out.String(), "session  system") {
+		t.Errorf("expected the services to be listed in %q", out.String())
+	}
+	if password, err := r.ReadPassword("password: "); err != nil || password != "secret" || strings.Contains(out.String(), "secret") {
+		t.Errorf("expected the password to be read without echo got %q %v", password, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// terminalReader reads lines from a terminal in raw mode with line editing, history and tab completion
+type terminalReader struct {
+	*term.Terminal
+	prompt string
+}
+
+// newTerminalReader puts in into raw mode when it is a terminal, returning nil when it is not.
+// Tab calls complete with the line and cursor position, the possible words are listed when it can not
+// complete the word. restore must be called to put the terminal back
+func newTerminalReader(in *os.File, out io.Writer, complete func(line string, pos int) (string, int, []string)) (*terminalReader, func(), error) {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, nil, nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, out}, complete), func() { term.Restore(fd, state) }, nil
+}
+
+// newTerminal returns a terminalReader for rw, which must already be in raw mode
+func newTerminal(rw io.ReadWriter, complete func(line string, pos int) (string, int, []string)) *terminalReader {
+	r := &terminalReader{Terminal: term.NewTerminal(rw, "")}
+	r.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		newLine, newPos, matches := complete(line, pos)
+		if len(matches) > 0 {
+			//-- Keep what was typed above the list, the prompt and line are drawn again below it
+			io.WriteString(r, r.prompt+line+"\n"+strings.Join(matches, "  ")+"\n")
+		}
+		return newLine, newPos, true
+	}
+	return r
+}
+
+// ReadLine shows prompt and reads a line, Ctrl-D on an empty line or Ctrl-C returns io.EOF
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	r.prompt = prompt
+	r.SetPrompt(prompt)
+	return r.Terminal.ReadLine()
+}
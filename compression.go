@@ -0,0 +1,171 @@
+package apiLib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultCompressionThreshold is the request body size in bytes above which requests are gzipped
+const defaultCompressionThreshold = 1024
+
+// CompressionStats holds the byte counts of request and response bodies since compression was enabled
+// so the saving can be measured
+type CompressionStats struct {
+	RequestBytes          uint64
+	RequestBytesSent      uint64
+	ResponseBytes         uint64
+	ResponseBytesReceived uint64
+}
+
+// compressionCounters are updated as bodies are read, which may be on another goroutine
+type compressionCounters struct {
+	requestBytes          atomic.Uint64
+	requestBytesSent      atomic.Uint64
+	responseBytes         atomic.Uint64
+	responseBytesReceived atomic.Uint64
+}
+
+// SetCompression enables gzip compression of request bodies above the compression threshold
+// and asks the server to compress responses with gzip or deflate, which are decompressed transparently.
+// Expects a bool of true or false
+// conn.SetCompression(true)
+func (xmlmc *XmlmcInstStruct) SetCompression(b bool) {
+	xmlmc.compression = b
+}
+
+// SetCompressionThreshold sets the request body size in bytes above which requests are gzipped
+// when compression is enabled, it defaults to 1024
+// conn.SetCompressionThreshold(4096)
+func (xmlmc *XmlmcInstStruct) SetCompressionThreshold(threshold int) {
+	xmlmc.compressionThreshold = threshold
+}
+
+// GetCompressionStats returns the byte counts of request and response bodies sent and received with compression enabled
+// stats := conn.GetCompressionStats()
+func (xmlmc *XmlmcInstStruct) GetCompressionStats() CompressionStats {
+	return CompressionStats{
+		RequestBytes:          xmlmc.compressionCounters.requestBytes.Load(),
+		RequestBytesSent:      xmlmc.compressionCounters.requestBytesSent.Load(),
+		ResponseBytes:         xmlmc.compressionCounters.responseBytes.Load(),
+		ResponseBytesReceived: xmlmc.compressionCounters.responseBytesReceived.Load(),
+	}
+}
+
+// compressRequest gzips the request body when compression is enabled and the body is over the threshold.
+// Bodies with streamed params have no known size so are always compressed as they are read
+func (xmlmc *XmlmcInstStruct) compressRequest(req *http.Request, call *methodCall) {
+	if !xmlmc.compression {
+		return
+	}
+	counters := &xmlmc.compressionCounters
+	if len(call.streams) == 0 && len(call.body) < xmlmc.compressionThreshold {
+		counters.requestBytes.Add(uint64(len(call.body)))
+		counters.requestBytesSent.Add(uint64(len(call.body)))
+		return
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.GetBody = nil
+	body := &byteCountingReader{r: call.requestBody(), counter: &counters.requestBytes}
+
+	if len(call.streams) == 0 {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		io.Copy(gz, body)
+		gz.Close()
+		counters.requestBytesSent.Add(uint64(compressed.Len()))
+		req.ContentLength = int64(compressed.Len())
+		req.Body = io.NopCloser(&compressed)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, body)
+		if err == nil {
+			err = gz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req.ContentLength = -1
+	req.Body = &byteCountingReadCloser{byteCountingReader{r: pr, counter: &counters.requestBytesSent}, pr}
+}
+
+// decompressResponse replaces the body of the response with its decompressed content
+func (xmlmc *XmlmcInstStruct) decompressResponse(resp *http.Response) error {
+	if !xmlmc.compression {
+		return nil
+	}
+	counters := &xmlmc.compressionCounters
+	wire := &byteCountingReader{r: resp.Body, counter: &counters.responseBytesReceived}
+	//-- Error pages from a gateway may claim an encoding they do not have, so the start of the body
+	//-- is kept to send them on as they are when they can not be decoded
+	header := &headerRecorder{}
+	src := io.Reader(wire)
+	if resp.StatusCode != http.StatusOK {
+		src = io.TeeReader(wire, header)
+	}
+	var decoded io.Reader = wire
+	var err error
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		decoded, err = gzip.NewReader(src)
+	case "deflate":
+		decoded, err = zlib.NewReader(src)
+	}
+	header.stopped = true
+	if err != nil {
+		if resp.StatusCode == http.StatusOK {
+			return err
+		}
+		decoded = io.MultiReader(&header.buf, wire)
+	}
+	if decoded != io.Reader(wire) && err == nil {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	resp.Body = &byteCountingReadCloser{byteCountingReader{r: decoded, counter: &counters.responseBytes}, resp.Body}
+	return nil
+}
+
+// headerRecorder keeps what is written to it until it is stopped
+type headerRecorder struct {
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (h *headerRecorder) Write(p []byte) (int, error) {
+	if !h.stopped {
+		h.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// byteCountingReader adds the number of bytes read to counter
+type byteCountingReader struct {
+	r       io.Reader
+	counter *atomic.Uint64
+}
+
+func (c *byteCountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(uint64(n))
+	return n, err
+}
+
+// byteCountingReadCloser counts the bytes read and closes the underlying body
+type byteCountingReadCloser struct {
+	byteCountingReader
+	closer io.Closer
+}
+
+func (c *byteCountingReadCloser) Close() error {
+	return c.closer.Close()
+}
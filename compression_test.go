@@ -0,0 +1,102 @@
+package apiLib
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	result := `<methodCallResult status="ok"><params>` + strings.Repeat("<row><h_id>1</h_id></row>", 1000) + `</params></methodCallResult>`
+	var gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		b, _ := io.ReadAll(body)
+		gotBody = string(b)
+		switch {
+		case strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && r.URL.Query().Get("method") == "gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, result)
+			gz.Close()
+		case strings.Contains(r.Header.Get("Accept-Encoding"), "deflate"):
+			w.Header().Set("Content-Encoding", "deflate")
+			zw := zlib.NewWriter(w)
+			io.WriteString(zw, result)
+			zw.Close()
+		default:
+			io.WriteString(w, result)
+		}
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetCompression(true)
+	_ = conn.SetParam("content", strings.Repeat("a", 2000))
+	body, err := conn.Invoke("data", "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != result || !strings.Contains(gotBody, strings.Repeat("a", 2000)) {
+		t.Errorf("Was expecting the request and response to be decompressed")
+	}
+	stats := conn.GetCompressionStats()
+	if stats.RequestBytesSent >= stats.RequestBytes || stats.ResponseBytesReceived >= stats.ResponseBytes {
+		t.Errorf("Was expecting compression to save bytes but got %+v", stats)
+	}
+	if stats.ResponseBytes != uint64(len(result)) {
+		t.Errorf("Was expecting %d response bytes but got %d", len(result), stats.ResponseBytes)
+	}
+
+	_ = conn.SetParamReader("content", strings.NewReader(strings.Repeat("b", 2000)))
+	body, err = conn.Invoke("data", "deflate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != result || !strings.Contains(gotBody, "<content>YmJi") {
+		t.Errorf("Was expecting the streamed request and deflated response to be decompressed")
+	}
+
+	conn.SetCompression(false)
+	stats = conn.GetCompressionStats()
+	_ = conn.SetParam("content", strings.Repeat("a", 2000))
+	if _, err = conn.Invoke("data", "gzip"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.GetCompressionStats() != stats {
+		t.Errorf("Was not expecting stats to change with compression disabled")
+	}
+}
+
+func TestCompressionErrorBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "Bad Gateway")
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetCompression(true)
+	_, err := conn.Invoke("data", "gzip")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway || string(httpErr.Body) != "Bad Gateway" {
+		t.Fatalf("Was expecting an HTTPError with status 502 and the body as sent but got %v", err)
+	}
+	if !IsTransient(err) {
+		t.Errorf("Was expecting the undecodable 502 to be transient")
+	}
+}
@@ -0,0 +1,42 @@
+package apiLib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DavRequest sends a request to the instance dav endpoint using the same transport, credentials and session
+// as xmlmc calls. The path is relative to the dav endpoint and the caller must close the response body
+// resp, err := conn.DavRequest("GET", "session/export.csv", nil)
+func (xmlmc *XmlmcInstStruct) DavRequest(method string, path string, body io.Reader) (*http.Response, error) {
+	return xmlmc.davRequest(context.Background(), method, path, body)
+}
+
+// davRequest sends a dav request within ctx
+func (xmlmc *XmlmcInstStruct) davRequest(parent context.Context, method string, path string, body io.Reader) (*http.Response, error) {
+	if xmlmc.DavEndpoint == "" {
+		return nil, ErrNoDavEndpoint
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(xmlmc.DavEndpoint, "/")+"/"+strings.TrimPrefix(path, "/"), body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreateRequest, err)
+	}
+	if xmlmc.apiKey != "" {
+		req.Header.Add("Authorization", "ESP-APIKEY "+xmlmc.apiKey)
+	}
+	req.Header.Set("User-Agent", xmlmc.userAgent)
+	if sessionID := xmlmc.GetSessionID(); sessionID != "" {
+		req.Header.Add("Cookie", sessionID)
+	}
+	ctx, cancel := xmlmc.timeoutContext(parent, "", "")
+	resp, err := xmlmc.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package apiLib
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dryRunXMLResult and dryRunJSONResult are the synthetic results returned for calls made in dry run mode
+const (
+	dryRunXMLResult  = `<methodCallResult status="ok"><params/></methodCallResult>`
+	dryRunJSONResult = `{"@status":true,"params":{}}`
+)
+
+// DryRunRequest is the request that would have been sent for a call made in dry run mode,
+// with the API key, session and any secret params redacted
+type DryRunRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// String renders the request as it would be sent over the wire
+func (r *DryRunRequest) String() string {
+	var buf strings.Builder
+	buf.WriteString(r.Method + " " + r.URL + "\n")
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			buf.WriteString(name + ": " + value + "\n")
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(r.Body)
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// SetDryRun stops calls being sent. Invoke and the other call methods build the request as normal
+// and return a synthetic success with the request that would have been sent in Response.DryRun,
+// so workflows can be rehearsed without changing anything on the instance.
+// Streamed params are not read and are shown empty
+// conn.SetDryRun(true)
+func (xmlmc *XmlmcInstStruct) SetDryRun(b bool) {
+	xmlmc.dryRun = b
+}
+
+// SetDryRunWriter sets a writer that every request made in dry run mode is rendered to, nil stops the rendering
+// conn.SetDryRunWriter(os.Stdout)
+func (xmlmc *XmlmcInstStruct) SetDryRunWriter(w io.Writer) {
+	xmlmc.dryRunWriter = w
+}
+
+// dryRunSend renders the call rather than sending it and returns a synthetic successful response
+func (xmlmc *XmlmcInstStruct) dryRunSend(call *methodCall) (*http.Response, *Response, error) {
+	server := xmlmc.server
+	if candidates := xmlmc.candidates(); len(candidates) > 0 {
+		server = candidates[0]
+	}
+	strURL := server + "/" + call.service + "/?method=" + call.method
+	req, err := http.NewRequest("POST", strURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	requestID := newRequestID()
+	xmlmc.setHeaders(req, call, requestID)
+
+	dryRun := &DryRunRequest{Method: req.Method, URL: strURL, Header: RedactHeader(req.Header), Body: xmlmc.redactedBody(call)}
+	if xmlmc.dryRunWriter != nil {
+		io.WriteString(xmlmc.dryRunWriter, dryRun.String())
+	}
+
+	result := dryRunXMLResult
+	if xmlmc.jsonresp {
+		result = dryRunJSONResult
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "text/xmlmc")
+	if xmlmc.jsonresp {
+		header.Set("Content-Type", "text/json")
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewBufferString(result)),
+		Request:    req,
+	}
+	return resp, &Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		RequestID:  requestID,
+		SessionID:  xmlmc.GetSessionID(),
+		Endpoint:   server,
+		Attempts:   0,
+		DryRun:     dryRun,
+		start:      time.Now(),
+	}, nil
+}
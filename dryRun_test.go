@@ -0,0 +1,67 @@
+package apiLib
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer ts.Close()
+
+	var sink bytes.Buffer
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	conn.SetAPIKey("secretkey")
+	conn.SetSessionID("ESPSessionState=secretsession")
+	conn.SetDryRun(true)
+	conn.SetDryRunWriter(&sink)
+	conn.SetParam("userId", "admin")
+	conn.SetParamSecret("password", "Password1")
+
+	response, err := conn.InvokeResponse("session", "userLogon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 0 || conn.GetCount() != 0 {
+		t.Errorf("Was expecting nothing to be sent, %d requests and a count of %d", requests.Load(), conn.GetCount())
+	}
+	if response.String() != dryRunXMLResult || response.Err() != nil || response.DryRun == nil {
+		t.Fatalf("Was expecting a synthetic success got %s", response.String())
+	}
+	if conn.GetParam() != "<params></params>" {
+		t.Errorf("Was expecting the params to be cleared as after a real call %s", conn.GetParam())
+	}
+	dryRun := response.DryRun
+	if dryRun.URL != ts.URL+"/xmlmc//session/?method=userLogon" || dryRun.Header.Get("Authorization") != "ESP-APIKEY ********" || dryRun.Header.Get("Cookie") != "********" {
+		t.Errorf("Unexpected request %s", dryRun)
+	}
+	if !strings.Contains(string(dryRun.Body), "<userId>admin</userId><password>********</password>") {
+		t.Errorf("Was expecting the password to be redacted %s", dryRun.Body)
+	}
+	if sink.String() != dryRun.String() || strings.Contains(sink.String(), "secret") || strings.Contains(sink.String(), "UGFzc3dvcmQx") {
+		t.Errorf("Unexpected output %s", sink.String())
+	}
+
+	conn.SetJSONRequest(true)
+	conn.SetJSONResponse(true)
+	conn.SetParamSecret("password", "Password1")
+	result, err := conn.Invoke("session", "userLogon")
+	if err != nil || result != dryRunJSONResult {
+		t.Errorf("Was expecting a json synthetic result got %s %v", result, err)
+	}
+	if !strings.Contains(sink.String(), `"params":{"password":"********"}`) {
+		t.Errorf("Was expecting the json password to be redacted %s", sink.String())
+	}
+
+	conn.SetDryRun(false)
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil || requests.Load() != 1 {
+		t.Errorf("Was expecting the call to be sent once dry run is off")
+	}
+}
@@ -0,0 +1,207 @@
+package apiLib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+)
+
+// Errors returned by this package, use errors.Is to check for them as most are wrapped with more detail
+var (
+	ErrInstanceIDRequired   = errors.New("instanceid not provided")
+	ErrNameEmpty            = errors.New("Name Must contain at least one letter or number")
+	ErrNameInvalid          = errors.New("Name invalid only Numbers and letters can be used")
+	ErrElementEmpty         = errors.New("Element must have at least one letter or number")
+	ErrElementInvalid       = errors.New("Element invalid only Numbers and letters can be used")
+	ErrAttributeNameEmpty   = errors.New("Attribute name must contain at least one letter or number")
+	ErrAttributeNameInvalid = errors.New("Attribute name invalid only Numbers and letters can be used")
+	ErrEncodeValue          = errors.New("Could not clean the varValue input")
+	ErrUnbalancedElements   = errors.New("Unbalanced elements")
+	ErrStreamedJSON         = errors.New("Streamed params can not be sent as a JSON request")
+	ErrMixedContent         = errors.New("Elements with both text and child elements can not be sent as a JSON request")
+	ErrCreateRequest        = errors.New("Unable to create http request in esp_xmlmc.go")
+	ErrReadBody             = errors.New("Cant read the body of the response")
+	ErrPanic                = errors.New("Panic caught")
+	ErrNoTransport          = errors.New("TLS options can not be set without a transport")
+	ErrInvalidCA            = errors.New("No CA certificates could be parsed")
+	ErrTLSVersion           = errors.New("Minimum TLS version must be TLS 1.2 or TLS 1.3")
+	ErrInvalidPin           = errors.New("Invalid public key pin")
+	ErrPinMismatch          = errors.New("Server public key does not match a pinned key")
+	ErrInvalidProxy         = errors.New("Invalid proxy URL")
+	ErrNoDavEndpoint        = errors.New("No dav endpoint for this instance")
+	ErrNoEndpoint           = errors.New("No xmlmc endpoint found for this instance")
+	ErrHTTPStatus           = errors.New("Invalid HTTP Response")
+	ErrMethodFailed         = errors.New("xmlmc method failed")
+	ErrCircuitOpen          = errors.New("Circuit open")
+	ErrConfig               = errors.New("Invalid config")
+	ErrProfileNotFound      = errors.New("Profile not found")
+)
+
+// maxErrorBodySize is the most of an error response body kept in an HTTPError
+const maxErrorBodySize = 64 * 1024
+
+// HTTPError is returned by Invoke when the server responds with a status other than 200.
+// Use errors.As to get the status, headers and body the server sent
+//
+//	var httpErr *apiLib.HTTPError
+//	if errors.As(err, &httpErr) { fmt.Println(httpErr.StatusCode, string(httpErr.Body)) }
+type HTTPError struct {
+	StatusCode  int
+	Status      string
+	Header      http.Header
+	Body        []byte
+	Truncated   bool
+	MethodError *MethodError
+
+	//-- session is set when the call was authenticated by a session rather than an API key
+	session bool
+}
+
+func (e *HTTPError) Error() string {
+	errorString := fmt.Sprintf("Invalid HTTP Response: %d", e.StatusCode)
+	if e.MethodError != nil {
+		errorString += ": " + e.MethodError.Message
+	}
+	return errorString
+}
+
+// Unwrap allows errors.Is to match ErrHTTPStatus and errors.As to find the MethodError
+func (e *HTTPError) Unwrap() []error {
+	if e.MethodError != nil {
+		return []error{ErrHTTPStatus, e.MethodError}
+	}
+	return []error{ErrHTTPStatus}
+}
+
+// MethodError is the failure state returned by an xmlmc method
+type MethodError struct {
+	Code      string `xml:"code" json:"code"`
+	Service   string `xml:"service" json:"service"`
+	Operation string `xml:"operation" json:"operation"`
+	Message   string `xml:"error" json:"error"`
+
+	session bool
+}
+
+func (e *MethodError) Error() string {
+	if e.Service != "" || e.Operation != "" {
+		return fmt.Sprintf("%s::%s failed: %s", e.Service, e.Operation, e.Message)
+	}
+	return e.Message
+}
+
+// Is allows errors.Is to match ErrMethodFailed
+func (e *MethodError) Is(target error) bool {
+	return target == ErrMethodFailed
+}
+
+// parseMethodError returns the failure state of an xml or json methodCallResult, or nil if the
+// body is not a failed methodCallResult
+func parseMethodError(body []byte) *MethodError {
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var result struct {
+			Status *bool       `json:"@status"`
+			State  MethodError `json:"state"`
+		}
+		if json.Unmarshal(trimmed, &result) != nil || result.Status == nil || *result.Status {
+			return nil
+		}
+		return &result.State
+	}
+	var result struct {
+		Status string      `xml:"status,attr"`
+		State  MethodError `xml:"state"`
+	}
+	if xml.Unmarshal(trimmed, &result) != nil || result.Status != "fail" {
+		return nil
+	}
+	return &result.State
+}
+
+// The classifiers use the http status of an HTTPError or, when the server answered 200 or 500 with a failed
+// method, the code of the MethodError read as a status, so a code of 0404 is not found. A method failing with
+// the generic code 0200 is in none of the categories. IsAuthError, IsSessionExpired and IsNotFound never
+// match the same error
+
+// IsAuthError returns true if err is an authentication or authorisation failure, a 403 status
+// or a 401 status for a call authenticated with an API key. A 401 for a call using a session is IsSessionExpired
+func IsAuthError(err error) bool {
+	status, session := errorStatus(err)
+	return status == http.StatusForbidden || (status == http.StatusUnauthorized && !session)
+}
+
+// IsNotFound returns true if err is a 404 status, such as an unknown service, method or record
+func IsNotFound(err error) bool {
+	status, _ := errorStatus(err)
+	return status == http.StatusNotFound
+}
+
+// IsRateLimited returns true if err is a 429 status
+func IsRateLimited(err error) bool {
+	status, _ := errorStatus(err)
+	return status == http.StatusTooManyRequests
+}
+
+// IsTransient returns true if err is likely to succeed if the call is retried later,
+// network timeouts and resets, gateway errors, rate limiting and an open circuit breaker
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	if status, _ := errorStatus(err); status != 0 {
+		return status == http.StatusTooManyRequests || status == http.StatusBadGateway ||
+			status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsSessionExpired returns true if err is a 401 status for a call authenticated with a session,
+// which is no longer valid so a new logon is needed
+func IsSessionExpired(err error) bool {
+	status, session := errorStatus(err)
+	return status == http.StatusUnauthorized && session
+}
+
+// errorStatus returns the status err is classified by and whether the call was authenticated with a session,
+// or 0 when err has neither an HTTPError nor a MethodError with a numeric code
+func errorStatus(err error) (int, bool) {
+	status, session := 0, false
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		status, session = httpErr.StatusCode, httpErr.session
+	}
+	//-- A failed method answered with 200 or 500 is classified by its code
+	var methodErr *MethodError
+	if (status == 0 || status == http.StatusInternalServerError) && errors.As(err, &methodErr) {
+		if code, convErr := strconv.Atoi(methodErr.Code); convErr == nil && code != http.StatusOK {
+			return code, methodErr.session || session
+		}
+	}
+	return status, session
+}
+
+// httpStatus returns the status of an HTTPError in the chain of err
+func httpStatus(err error) (int, bool) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode, true
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package apiLib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	conn := NewXmlmcInstance("https://devapi.hornbill.com/test/")
+	if err := conn.SetParam("", "blank"); !errors.Is(err, ErrNameEmpty) {
+		t.Errorf("Was expecting ErrNameEmpty but got %v", err)
+	}
+	if err := conn.CloseElement("User"); !errors.Is(err, ErrUnbalancedElements) {
+		t.Errorf("Was expecting ErrUnbalancedElements but got %v", err)
+	}
+	if _, err := GetZoneInfo(""); !errors.Is(err, ErrInstanceIDRequired) {
+		t.Errorf("Was expecting ErrInstanceIDRequired but got %v", err)
+	}
+	conn.DavEndpoint = ""
+	if _, err := conn.DavRequest("GET", "file.txt", nil); !errors.Is(err, ErrNoDavEndpoint) {
+		t.Errorf("Was expecting ErrNoDavEndpoint but got %v", err)
+	}
+}
+
+func TestGetZoneInfoNotFound(t *testing.T) {
+	var lookups []string
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		lookups = append(lookups, req.URL.Host)
+		return &http.Response{StatusCode: 404, Status: "404 Not Found", Header: http.Header{}, Body: io.NopCloser(strings.NewReader("Not Found")), Request: req}, nil
+	})}
+	_, err := GetZoneInfoWithClient("unknown", client)
+	var httpErr *HTTPError
+	if !IsNotFound(err) || !errors.As(err, &httpErr) || string(httpErr.Body) != "Not Found" {
+		t.Errorf("Was expecting a not found HTTPError got %v", err)
+	}
+	if len(lookups) != 2 || lookups[1] != "files.hornbill.co" {
+		t.Errorf("Was expecting the lookup to fall over to files.hornbill.co %v", lookups)
+	}
+}
+
+var classifierTests = []struct {
+	name        string
+	err         error
+	auth        bool
+	notFound    bool
+	rateLimited bool
+	transient   bool
+	expired     bool
+}{
+	{"401", &HTTPError{StatusCode: 401}, true, false, false, false, false},
+	{"404", &HTTPError{StatusCode: 404}, false, true, false, false, false},
+	{"429", &HTTPError{StatusCode: 429}, false, false, true, true, false},
+	{"503", &HTTPError{StatusCode: 503}, false, false, false, true, false},
+	{"500", &HTTPError{StatusCode: 500}, false, false, false, false, false},
+	{"401 session", &HTTPError{StatusCode: 401, session: true}, false, false, false, false, true},
+	{"403 session", &HTTPError{StatusCode: 403, session: true}, true, false, false, false, false},
+	{"expired", &MethodError{Code: "0401", session: true}, false, false, false, false, true},
+	{"wrapped expired", fmt.Errorf("calling: %w", &HTTPError{StatusCode: 500, session: true, MethodError: &MethodError{Code: "0401", session: true}}), false, false, false, false, true},
+	{"wrapped 404", fmt.Errorf("calling: %w", &HTTPError{StatusCode: 404, MethodError: &MethodError{Code: "0200"}}), false, true, false, false, false},
+	{"missing", &MethodError{Code: "0404", Message: "The entity record does not exist"}, false, true, false, false, false},
+	{"rights", &MethodError{Code: "0403", Message: "You do not have permission to perform this action"}, true, false, false, false, false},
+	{"failed", &MethodError{Code: "0200", Message: "Session not found"}, false, false, false, false, false},
+	{"unavailable", &MethodError{Code: "0503"}, false, false, false, true, false},
+	{"eof", fmt.Errorf("reading: %w", io.EOF), false, false, false, false, false},
+	{"refused", &net.OpError{Op: "dial", Err: fmt.Errorf("connect: %w", syscall.ECONNREFUSED)}, false, false, false, true, false},
+	{"deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), false, false, false, true, false},
+	{"canceled", context.Canceled, false, false, false, false, false},
+	{"nil", nil, false, false, false, false, false},
+}
+
+func TestClassifiers(t *testing.T) {
+	for _, tt := range classifierTests {
+		if IsAuthError(tt.err) != tt.auth || IsNotFound(tt.err) != tt.notFound || IsRateLimited(tt.err) != tt.rateLimited ||
+			IsTransient(tt.err) != tt.transient || IsSessionExpired(tt.err) != tt.expired {
+			t.Errorf("for: %s got auth %t not found %t rate limited %t transient %t expired %t", tt.name, IsAuthError(tt.err),
+				IsNotFound(tt.err), IsRateLimited(tt.err), IsTransient(tt.err), IsSessionExpired(tt.err))
+		}
+	}
+}
+
+func TestResponseMethodError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<methodCallResult status="fail"><state><code>0401</code><service>session</service>`+
+			`<operation>getSessionInfo</operation><error>Session not found</error></state></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	response, err := conn.InvokeResponse("session", "getSessionInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := response.Err(); !errors.Is(err, ErrMethodFailed) || !IsSessionExpired(err) || IsAuthError(err) {
+		t.Errorf("Was expecting a session expired method error but got %v", err)
+	}
+	//-- The same code for a call made with an API key is an authentication failure
+	conn.SetAPIKey("key")
+	if response, err = conn.InvokeResponse("session", "getSessionInfo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := response.Err(); !IsAuthError(err) || IsSessionExpired(err) {
+		t.Errorf("Was expecting an auth error for an API key but got %v", err)
+	}
+	var httpErr error = &HTTPError{StatusCode: 500, MethodError: response.MethodError()}
+	var methodErr *MethodError
+	if !errors.Is(httpErr, ErrHTTPStatus) || !errors.As(httpErr, &methodErr) || methodErr.Code != "0401" {
+		t.Errorf("Was expecting the HTTPError to unwrap to its MethodError")
+	}
+}
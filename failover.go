@@ -0,0 +1,214 @@
+package apiLib
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// defaultFailoverCooldown is how long an endpoint that failed is skipped before it is tried again
+const defaultFailoverCooldown = 30 * time.Second
+
+// idempotentPrefixes are the method name prefixes treated as safe to send again to another endpoint,
+// they must be a whole word of the method name so isValid matches but issueLicence does not
+var idempotentPrefixes = []string{"get", "list", "is", "ping", "browse", "search"}
+
+// EndpointStatus is the health of one of the candidate endpoints of an instance
+type EndpointStatus struct {
+	URL       string
+	Healthy   bool
+	Failures  int
+	LastError error
+	DownUntil time.Time
+}
+
+// endpoints are the candidate xmlmc endpoints of an instance in order of preference
+type endpoints struct {
+	mu       sync.Mutex
+	list     []*EndpointStatus
+	cooldown time.Duration
+}
+
+// SetEndpoints sets the ordered list of xmlmc endpoints calls are sent to, the first healthy endpoint is used.
+// An instance looked up by name starts with the apiEndpoint and endpoint of its zone info
+// conn.SetEndpoints("https://eurapi.hornbill.com/yourinstance/xmlmc/", "https://eur.hornbill.com/yourinstance/xmlmc/")
+func (xmlmc *XmlmcInstStruct) SetEndpoints(urls ...string) {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	xmlmc.endpoints.list = nil
+	for _, url := range urls {
+		xmlmc.endpoints.add(url)
+	}
+	if len(xmlmc.endpoints.list) > 0 {
+		xmlmc.server = xmlmc.endpoints.list[0].URL
+	}
+}
+
+// GetEndpointStatus returns the health of each candidate endpoint in order of preference
+// for _, status := range conn.GetEndpointStatus() { fmt.Println(status.URL, status.Healthy) }
+func (xmlmc *XmlmcInstStruct) GetEndpointStatus() []EndpointStatus {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	now := time.Now()
+	statuses := make([]EndpointStatus, 0, len(xmlmc.endpoints.list))
+	for _, endpoint := range xmlmc.endpoints.list {
+		status := *endpoint
+		status.Healthy = !now.Before(endpoint.DownUntil)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SetFailoverCooldown sets how long an endpoint that failed is skipped before calls go back to it, it defaults to 30 seconds
+// conn.SetFailoverCooldown(time.Minute)
+func (xmlmc *XmlmcInstStruct) SetFailoverCooldown(d time.Duration) {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	xmlmc.endpoints.cooldown = d
+}
+
+// SetIdempotent sets whether a method can be sent again to another endpoint after a failure that may have reached the server.
+// An empty method name applies to every method of the service. By default methods whose first word is get, list, is, ping,
+// browse or search are idempotent. Calls that could not connect at all fail over whether or not they are idempotent
+// conn.SetIdempotent("data", "queryExec", true)
+func (xmlmc *XmlmcInstStruct) SetIdempotent(servicename string, methodname string, idempotent bool) {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	if xmlmc.idempotent == nil {
+		xmlmc.idempotent = make(map[string]bool)
+	}
+	xmlmc.idempotent[servicename+"::"+methodname] = idempotent
+}
+
+// isIdempotent returns true if the method is safe to send again
+func (xmlmc *XmlmcInstStruct) isIdempotent(servicename string, methodname string) bool {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	if idempotent, ok := xmlmc.idempotent[servicename+"::"+methodname]; ok {
+		return idempotent
+	}
+	if idempotent, ok := xmlmc.idempotent[servicename+"::"]; ok {
+		return idempotent
+	}
+	lower := strings.ToLower(methodname)
+	for _, prefix := range idempotentPrefixes {
+		if !strings.HasPrefix(lower, prefix) {
+			continue
+		}
+		//-- The prefix must end the name or be followed by the upper case start of the next word
+		if len(methodname) == len(prefix) || unicode.IsUpper(rune(methodname[len(prefix)])) {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends an endpoint unless it is already in the list
+func (e *endpoints) add(url string) {
+	if url == "" {
+		return
+	}
+	for _, endpoint := range e.list {
+		if endpoint.URL == url {
+			return
+		}
+	}
+	e.list = append(e.list, &EndpointStatus{URL: url})
+}
+
+// candidates returns the endpoints to try in order, healthy endpoints first in order of preference
+// then those still cooling down with the soonest to recover first
+func (xmlmc *XmlmcInstStruct) candidates() []string {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	if len(xmlmc.endpoints.list) == 0 {
+		return []string{xmlmc.server}
+	}
+	now := time.Now()
+	var healthy []string
+	var down []*EndpointStatus
+	for _, endpoint := range xmlmc.endpoints.list {
+		if now.Before(endpoint.DownUntil) {
+			down = append(down, endpoint)
+			continue
+		}
+		healthy = append(healthy, endpoint.URL)
+	}
+	for len(down) > 0 {
+		soonest := 0
+		for i, endpoint := range down {
+			if endpoint.DownUntil.Before(down[soonest].DownUntil) {
+				soonest = i
+			}
+		}
+		healthy = append(healthy, down[soonest].URL)
+		down = append(down[:soonest], down[soonest+1:]...)
+	}
+	return healthy
+}
+
+// markEndpoint records the outcome of a call to an endpoint, failures that suggest the endpoint is
+// unreachable take it out of use until the cooldown has passed
+func (xmlmc *XmlmcInstStruct) markEndpoint(url string, err error) {
+	xmlmc.endpoints.mu.Lock()
+	defer xmlmc.endpoints.mu.Unlock()
+	for _, endpoint := range xmlmc.endpoints.list {
+		if endpoint.URL != url {
+			continue
+		}
+		if err == nil {
+			endpoint.Failures = 0
+			endpoint.LastError = nil
+			endpoint.DownUntil = time.Time{}
+			return
+		}
+		if !isEndpointFailure(err) {
+			return
+		}
+		cooldown := xmlmc.endpoints.cooldown
+		if cooldown == 0 {
+			cooldown = defaultFailoverCooldown
+		}
+		endpoint.Failures++
+		endpoint.LastError = err
+		endpoint.DownUntil = time.Now().Add(cooldown)
+		return
+	}
+}
+
+// canFailover returns true if a call that failed with err can be sent to the next endpoint.
+// Streamed params can only be read once so those calls are never sent again
+func (xmlmc *XmlmcInstStruct) canFailover(call *methodCall, err error) bool {
+	if len(call.streams) > 0 {
+		return false
+	}
+	if isDialError(err) {
+		return true
+	}
+	return isEndpointFailure(err) && xmlmc.isIdempotent(call.service, call.method)
+}
+
+// isDialError returns true if err happened before the request could reach the server
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// isEndpointFailure returns true if err suggests the endpoint rather than the call is at fault
+func isEndpointFailure(err error) bool {
+	if isDialError(err) {
+		return true
+	}
+	if status, ok := httpStatus(err); ok {
+		return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	}
+	return !errors.Is(err, ErrPanic) && !errors.Is(err, ErrCreateRequest) && IsTransient(err)
+}
@@ -0,0 +1,183 @@
+package apiLib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	var primaryDown atomic.Bool
+	var primaryCalls, secondaryCalls atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls.Add(1)
+		if primaryDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls.Add(1)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer secondary.Close()
+
+	conn := NewXmlmcInstance(primary.URL)
+	conn.SetEndpoints(primary.URL, secondary.URL)
+	conn.SetFailoverCooldown(100 * time.Millisecond)
+
+	//-- A call that may have reached the server is only sent again when it is idempotent
+	primaryDown.Store(true)
+	if _, err := conn.InvokeResponse("session", "userLogon"); !errors.Is(err, ErrHTTPStatus) || secondaryCalls.Load() != 0 {
+		t.Errorf("Was expecting userLogon not to fail over, got %v", err)
+	}
+	if status := conn.GetEndpointStatus(); status[0].Healthy || status[0].Failures != 1 || !status[1].Healthy {
+		t.Errorf("Was expecting the primary to be marked down %+v", status)
+	}
+	conn.markEndpoint(primary.URL, nil)
+
+	var debug strings.Builder
+	conn.SetDebugWriter(&debug)
+	response, err := conn.InvokeResponse("system", "getSystemInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDebugWriter(nil)
+	if !strings.Contains(debug.String(), "Endpoint "+primary.URL+" failed, trying the next") {
+		t.Errorf("Was expecting the failover in the debug output %s", debug.String())
+	}
+	if response.Attempts != 2 || response.Endpoint != secondary.URL {
+		t.Errorf("Was expecting to fail over to the secondary, attempts %d endpoint %s", response.Attempts, response.Endpoint)
+	}
+
+	//-- While the primary cools down calls go straight to the secondary
+	primaryCalls.Store(0)
+	response, err = conn.InvokeResponse("session", "userLogon")
+	if err != nil || response.Attempts != 1 || response.Endpoint != secondary.URL || primaryCalls.Load() != 0 {
+		t.Errorf("Was expecting the secondary to be used while the primary is down %v", err)
+	}
+
+	//-- Once the cooldown has passed calls fail back to the primary
+	primaryDown.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	response, err = conn.InvokeResponse("session", "userLogon")
+	if err != nil || response.Endpoint != primary.URL {
+		t.Errorf("Was expecting to fail back to the primary %v", err)
+	}
+	if status := conn.GetEndpointStatus(); !status[0].Healthy || status[0].Failures != 0 {
+		t.Errorf("Was expecting the primary to be healthy %+v", status)
+	}
+}
+
+func TestFailoverCallerDeadline(t *testing.T) {
+	var calls atomic.Int64
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}
+	primary := httptest.NewServer(http.HandlerFunc(slow))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(slow))
+	defer secondary.Close()
+
+	conn := NewXmlmcInstance(primary.URL)
+	conn.SetEndpoints(primary.URL, secondary.URL)
+
+	//-- The deadline of the caller expiring is not the endpoint failing so neither is marked down
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.InvokeAsync(ctx, "system", "getSystemInfo").Wait(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Was expecting the deadline of the caller got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Was expecting the call not to fail over, got %d calls", calls.Load())
+	}
+	for _, status := range conn.GetEndpointStatus() {
+		if !status.Healthy || status.Failures != 0 {
+			t.Errorf("Was expecting %s to stay healthy %+v", status.URL, status)
+		}
+	}
+}
+
+func TestFailoverDialError(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer working.Close()
+
+	conn := NewXmlmcInstance(closedURL)
+	conn.SetEndpoints(closedURL, working.URL)
+
+	//-- Streamed params can not be read again so are not sent to another endpoint
+	conn.SetParamReader("file", strings.NewReader("content"))
+	if _, err := conn.InvokeResponse("session", "userLogon"); err == nil {
+		t.Errorf("Was expecting a streamed call not to fail over")
+	}
+	conn.ClearParam()
+	conn.markEndpoint(closedURL, nil)
+
+	//-- A call that could not connect fails over even if it is not idempotent
+	response, err := conn.InvokeResponse("session", "userLogon")
+	if err != nil || response.Attempts != 2 || response.Endpoint != working.URL {
+		t.Errorf("Was expecting a refused connection to fail over %v", err)
+	}
+}
+
+func TestZoneInfoEndpoints(t *testing.T) {
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"zoneinfo":{"endpoint":"https://eur.example.test/test/","apiEndpoint":"https://eurapi.example.test/test/xmlmc/"}}`
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(bytes.NewBufferString(body)), Request: req}, nil
+	})}
+	conn := NewXmlmcInstanceWithClient("test", client)
+	status := conn.GetEndpointStatus()
+	if len(status) != 2 || status[0].URL != "https://eurapi.example.test/test/xmlmc/" || status[1].URL != "https://eur.example.test/test/xmlmc/" {
+		t.Errorf("Was expecting the api endpoint then the endpoint %+v", status)
+	}
+	if conn.GetServerURL() != status[0].URL {
+		t.Errorf("Was expecting the api endpoint to be the server %s", conn.GetServerURL())
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	conn := NewXmlmcInstance("http://127.0.0.1/test/xmlmc/")
+	conn.SetIdempotent("data", "queryExec", true)
+	conn.SetIdempotent("admin", "", false)
+	tests := []struct {
+		service, method string
+		expected        bool
+	}{
+		{"system", "pingCheck", true},
+		{"session", "getSessionInfo", true},
+		{"session", "userLogon", false},
+		{"data", "queryExec", true},
+		{"data", "entityAddRecord", false},
+		{"admin", "getInstanceInfo", false},
+		{"apps", "isValidUser", true},
+		{"apps", "issueLicence", false},
+		{"apps", "listen", false},
+		{"system", "ping", true},
+	}
+	for _, test := range tests {
+		if got := conn.isIdempotent(test.service, test.method); got != test.expected {
+			t.Errorf("%s::%s expected %v got %v", test.service, test.method, test.expected, got)
+		}
+	}
+}
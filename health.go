@@ -0,0 +1,166 @@
+package apiLib
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthOptions choose the checks run by HealthCheck, system::pingCheck is always run
+type HealthOptions struct {
+	//-- ZoneInfo looks up the zone info of the instance to report its stream and any maintenance message
+	ZoneInfo bool
+	//-- Dav checks the dav endpoint answers
+	Dav bool
+	//-- Timeout limits the whole health check, the timeout of the instance applies when it is 0.
+	//-- A check that runs out of time does not take endpoints out of use or count against their circuit
+	Timeout time.Duration
+	//-- CacheFor is how long HealthHandler reuses a report before checking again
+	CacheFor time.Duration
+}
+
+// HealthCheckResult is the outcome of one check
+type HealthCheckResult struct {
+	Name    string        `json:"name"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latencyNs"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HealthReport is the health of an instance returned by HealthCheck
+type HealthReport struct {
+	Healthy   bool                `json:"healthy"`
+	Endpoint  string              `json:"endpoint"`
+	Latency   time.Duration       `json:"latencyNs"`
+	Stream    string              `json:"stream,omitempty"`
+	Message   string              `json:"message,omitempty"`
+	Checks    []HealthCheckResult `json:"checks"`
+	CheckedAt time.Time           `json:"checkedAt"`
+}
+
+// HealthCheck calls system::pingCheck, and the zone info lookup and dav endpoint when set in opts,
+// reporting the latency of each. The params being built are left untouched
+// report := conn.HealthCheck(ctx, apiLib.HealthOptions{ZoneInfo: true})
+func (xmlmc *XmlmcInstStruct) HealthCheck(ctx context.Context, opts HealthOptions) *HealthReport {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	report := &HealthReport{Healthy: true, Endpoint: xmlmc.server, Stream: xmlmc.stream, CheckedAt: time.Now()}
+	addCheck := func(name string, start time.Time, err error) {
+		check := HealthCheckResult{Name: name, Healthy: err == nil, Latency: time.Since(start)}
+		if err != nil {
+			check.Error = err.Error()
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	start := time.Now()
+	endpoint, err := xmlmc.pingCheck(ctx)
+	report.Latency = time.Since(start)
+	if endpoint != "" {
+		report.Endpoint = endpoint
+	}
+	addCheck("pingCheck", start, err)
+
+	if opts.ZoneInfo && xmlmc.instanceID != "" {
+		start = time.Now()
+		//-- The instance client has no timeout of its own so the lookup is bounded like a call
+		zoneCtx, cancel := xmlmc.timeoutContext(ctx, "", "")
+		zoneInfo, err := getZoneInfo(zoneCtx, xmlmc.httpClient(), xmlmc.instanceID)
+		cancel()
+		if err == nil {
+			if zoneInfo.Zoneinfo.Stream != "" {
+				report.Stream = zoneInfo.Zoneinfo.Stream
+			}
+			report.Message = zoneInfo.Zoneinfo.Message
+		}
+		addCheck("zoneInfo", start, err)
+	}
+
+	if opts.Dav {
+		start = time.Now()
+		resp, err := xmlmc.davRequest(ctx, http.MethodOptions, "", nil)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			//-- Any answer short of a server error shows the dav endpoint is reachable
+			if resp.StatusCode >= 500 {
+				err = &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header}
+			}
+		}
+		addCheck("dav", start, err)
+	}
+	return report
+}
+
+// pingCheck calls system::pingCheck without the params being built and returns the endpoint that answered
+func (xmlmc *XmlmcInstStruct) pingCheck(ctx context.Context) (string, error) {
+	body, contentType, err := xmlmc.buildMethodCallBody("system", "pingCheck", "")
+	if err != nil {
+		return "", err
+	}
+	call := &methodCall{service: "system", method: "pingCheck", body: body, contentType: contentType, ctx: ctx}
+	response, err := xmlmc.invoke(call)
+	if err != nil {
+		return "", err
+	}
+	return response.Endpoint, response.Err()
+}
+
+// HealthHandler returns an http.Handler for readiness probes which answers 200 when the instance is healthy
+// and 503 when it is not, with the HealthReport as json. One check runs at a time and the last report
+// is reused for opts.CacheFor so frequent probes do not each call the instance. A probe that gives up
+// before the check finishes is answered unhealthy while the check carries on for the next probe
+// http.Handle("/ready", conn.HealthHandler(apiLib.HealthOptions{Timeout: 5 * time.Second, CacheFor: 10 * time.Second}))
+func (xmlmc *XmlmcInstStruct) HealthHandler(opts HealthOptions) http.Handler {
+	var mu sync.Mutex
+	var last *HealthReport
+	var running chan struct{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		report := last
+		if report == nil || time.Since(report.CheckedAt) >= opts.CacheFor {
+			//-- The check is not tied to the probe that started it, and is bounded by the timeouts
+			//-- of the checks, so the lock is never held while waiting on it
+			done := running
+			if done == nil {
+				done = make(chan struct{})
+				running = done
+				go func() {
+					checked := xmlmc.HealthCheck(context.Background(), opts)
+					mu.Lock()
+					last, running = checked, nil
+					mu.Unlock()
+					close(done)
+				}()
+			}
+			mu.Unlock()
+			select {
+			case <-done:
+				mu.Lock()
+				report = last
+				mu.Unlock()
+			case <-r.Context().Done():
+				report = &HealthReport{Endpoint: xmlmc.server, Stream: xmlmc.stream, CheckedAt: time.Now()}
+				report.Checks = []HealthCheckResult{{Name: "probe", Error: r.Context().Err().Error()}}
+			}
+		} else {
+			mu.Unlock()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
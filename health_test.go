@@ -0,0 +1,163 @@
+package apiLib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var failing atomic.Bool
+	var pings atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/dav/") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `method="pingCheck"`) || strings.Contains(string(body), "<params>") {
+			t.Errorf("Unexpected health check request %s", body)
+		}
+		pings.Add(1)
+		if failing.Load() {
+			fmt.Fprint(w, `<methodCallResult status="fail"><state><code>0200</code><error>down for maintenance</error></state></methodCallResult>`)
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	conn.SetParam("userId", "admin")
+	report := conn.HealthCheck(context.Background(), HealthOptions{Dav: true})
+	if !report.Healthy || len(report.Checks) != 2 || report.Checks[1].Name != "dav" || !report.Checks[1].Healthy {
+		t.Errorf("Was expecting a healthy report %+v", report)
+	}
+	if report.Endpoint != ts.URL+"/xmlmc/" || report.Latency <= 0 {
+		t.Errorf("Was expecting the endpoint and latency %+v", report)
+	}
+	if conn.GetParam() != "<params><userId>admin</userId></params>" {
+		t.Errorf("Was expecting the params to be untouched %s", conn.GetParam())
+	}
+
+	failing.Store(true)
+	report = conn.HealthCheck(context.Background(), HealthOptions{})
+	if report.Healthy || !strings.Contains(report.Checks[0].Error, "down for maintenance") {
+		t.Errorf("Was expecting a failed pingCheck %+v", report)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	var failing atomic.Bool
+	var pings atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	handler := conn.HealthHandler(HealthOptions{CacheFor: 100 * time.Millisecond})
+	probe := func() (int, HealthReport) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+		var report HealthReport
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return recorder.Code, report
+	}
+
+	if code, report := probe(); code != http.StatusOK || !report.Healthy {
+		t.Errorf("Was expecting 200 and healthy got %d %+v", code, report)
+	}
+	failing.Store(true)
+	if code, _ := probe(); code != http.StatusOK || pings.Load() != 1 {
+		t.Errorf("Was expecting the cached report got %d after %d pings", code, pings.Load())
+	}
+	time.Sleep(150 * time.Millisecond)
+	if code, report := probe(); code != http.StatusServiceUnavailable || report.Healthy || report.Checks[0].Error == "" {
+		t.Errorf("Was expecting 503 and unhealthy got %d %+v", code, report)
+	}
+}
+
+func TestHealthCheckZoneInfoTimeout(t *testing.T) {
+	var hang atomic.Bool
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body := `<methodCallResult status="ok"><params/></methodCallResult>`
+		if strings.HasSuffix(req.URL.Path, "/zoneinfo") {
+			if hang.Load() {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			}
+			body = `{"zoneinfo":{"endpoint":"https://eur.example.test/test/","stream":"live"}}`
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})}
+	conn := NewXmlmcInstanceWithClient("test", client)
+	hang.Store(true)
+
+	start := time.Now()
+	report := conn.HealthCheck(context.Background(), HealthOptions{ZoneInfo: true, Timeout: 100 * time.Millisecond})
+	if time.Since(start) > time.Second || report.Healthy || len(report.Checks) != 2 || report.Checks[1].Healthy {
+		t.Errorf("Was expecting the zone info lookup to time out %+v", report)
+	}
+
+	//-- Probes that give up are answered while the check carries on
+	handler := conn.HealthHandler(HealthOptions{ZoneInfo: true, Timeout: 300 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		recorder := httptest.NewRecorder()
+		start = time.Now()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil).WithContext(ctx))
+		cancel()
+		if recorder.Code != http.StatusServiceUnavailable || time.Since(start) > 200*time.Millisecond {
+			t.Errorf("Was expecting probe %d to be answered when it gave up, got %d after %s", i, recorder.Code, time.Since(start))
+		}
+	}
+}
+
+func TestHealthCheckProbeTimeout(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}
+	primary := httptest.NewServer(http.HandlerFunc(slow))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(slow))
+	defer secondary.Close()
+
+	conn := NewXmlmcInstance(primary.URL)
+	conn.SetEndpoints(primary.URL, secondary.URL)
+	conn.SetCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 1})
+
+	//-- A slow probe timing out leaves the endpoints and their circuits for real calls untouched
+	report := conn.HealthCheck(context.Background(), HealthOptions{Timeout: 50 * time.Millisecond})
+	if report.Healthy {
+		t.Errorf("Was expecting the probe to time out %+v", report)
+	}
+	for _, status := range conn.GetEndpointStatus() {
+		if !status.Healthy || status.Failures != 0 {
+			t.Errorf("Was expecting %s to stay healthy %+v", status.URL, status)
+		}
+	}
+	for _, stats := range conn.GetCircuitBreakerStats() {
+		if stats.State != CircuitClosed || stats.ConsecutiveFailures != 0 {
+			t.Errorf("Was expecting the circuit of %s to stay closed %+v", stats.Endpoint, stats)
+		}
+	}
+}
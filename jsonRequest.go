@@ -0,0 +1,131 @@
+package apiLib
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// paramNode is one element of the params built with SetParam and OpenElement
+type paramNode struct {
+	name     string
+	attribs  []xml.Attr
+	text     string
+	children []*paramNode
+}
+
+// buildJSONMethodCall converts the params xml into the JSON methodCall representation
+// {"@service":"session","@method":"userLogon","@trace":"goApi","params":{"userId":"admin"}}
+// Repeated elements become arrays, attributes become "@name" members and the text of an element
+// with attributes is held in "#text". Elements with both text and child elements return ErrMixedContent
+func buildJSONMethodCall(servicename string, methodname string, trace string, paramsxml string) ([]byte, error) {
+	root, err := parseParams(paramsxml)
+	if err != nil {
+		return nil, err
+	}
+	var buf strings.Builder
+	buf.WriteString(`{"@service":`)
+	writeJSONString(&buf, servicename)
+	buf.WriteString(`,"@method":`)
+	writeJSONString(&buf, methodname)
+	buf.WriteString(`,"@trace":`)
+	writeJSONString(&buf, trace)
+	if len(root.children) > 0 {
+		buf.WriteString(`,"params":`)
+		writeJSONNode(&buf, root)
+	}
+	buf.WriteString("}")
+	return []byte(buf.String()), nil
+}
+
+// parseParams reads the params xml into a tree under an unnamed root node
+func parseParams(paramsxml string) (*paramNode, error) {
+	root := &paramNode{}
+	stack := []*paramNode{root}
+	decoder := xml.NewDecoder(strings.NewReader(paramsxml))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if len(stack) != 1 {
+				return nil, fmt.Errorf("%w: could not convert params to JSON", ErrUnbalancedElements)
+			}
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &paramNode{name: t.Name.Local, attribs: t.Attr}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			//-- JSON has no way to hold text alongside child elements so it would be lost
+			if node := stack[len(stack)-1]; len(node.children) > 0 && strings.TrimSpace(node.text) != "" {
+				return nil, fmt.Errorf("%w: %s", ErrMixedContent, node.name)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			stack[len(stack)-1].text += string(t)
+		}
+	}
+	return root, nil
+}
+
+// writeJSONNode writes the value of a node, a string for simple elements and an object otherwise
+func writeJSONNode(buf *strings.Builder, node *paramNode) {
+	if len(node.children) == 0 && len(node.attribs) == 0 {
+		writeJSONString(buf, node.text)
+		return
+	}
+	buf.WriteString("{")
+	first := true
+	member := func(name string) {
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		writeJSONString(buf, name)
+		buf.WriteString(":")
+	}
+	for _, attr := range node.attribs {
+		member("@" + attr.Name.Local)
+		writeJSONString(buf, attr.Value)
+	}
+	if len(node.children) == 0 {
+		member("#text")
+		writeJSONString(buf, node.text)
+	}
+	//-- Group repeated elements into arrays keeping the order they were first set
+	written := make(map[string]bool)
+	for _, child := range node.children {
+		if written[child.name] {
+			continue
+		}
+		written[child.name] = true
+		var siblings []*paramNode
+		for _, sibling := range node.children {
+			if sibling.name == child.name {
+				siblings = append(siblings, sibling)
+			}
+		}
+		member(child.name)
+		if len(siblings) == 1 {
+			writeJSONNode(buf, child)
+			continue
+		}
+		buf.WriteString("[")
+		for i, sibling := range siblings {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeJSONNode(buf, sibling)
+		}
+		buf.WriteString("]")
+	}
+	buf.WriteString("}")
+}
+
+func writeJSONString(buf *strings.Builder, s string) {
+	encoded, _ := json.Marshal(s)
+	buf.Write(encoded)
+}
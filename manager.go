@@ -0,0 +1,358 @@
+package apiLib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultParallelism is the number of instances ForEach runs at once
+const defaultParallelism = 8
+
+// defaultLookupTimeout limits the zone info lookup made when a connection is created
+const defaultLookupTimeout = 30 * time.Second
+
+// InstanceOptions are the credentials and limits applied to a connection when the Manager creates it
+type InstanceOptions struct {
+	//-- Endpoint is an xmlmc URL used instead of looking up the instance zone info
+	Endpoint  string
+	APIKey    string
+	SessionID string
+	Timeout   time.Duration
+	//-- MaxConcurrent limits the calls made at once through Do and ForEach, it defaults to 1
+	//-- as an XmlmcInstance holds the params being built
+	MaxConcurrent int
+	//-- Configure is called with each new connection once its zone info has been looked up to apply any other settings.
+	//-- Setting a proxy, round tripper, TLS or connection pool option gives the connection its own transport
+	//-- in place of the shared client
+	Configure func(conn *XmlmcInstStruct) error
+}
+
+// InstanceResult is the outcome of running a function against one instance with ForEach
+type InstanceResult struct {
+	InstanceID string
+	Value      interface{}
+	Err        error
+	Duration   time.Duration
+}
+
+// Results are the results of ForEach in the order the instances were given
+type Results []InstanceResult
+
+// Err returns the errors of every failed instance joined together, or nil if all succeeded
+func (r Results) Err() error {
+	var errs []error
+	for _, result := range r {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.InstanceID, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Failed returns the results of the instances that returned an error
+func (r Results) Failed() Results {
+	var failed Results
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Manager lazily creates and caches a connection per instance, sharing one http.Client between them
+// unless InstanceOptions.Configure sets transport options.
+// It is safe for concurrent use
+//
+//	manager := apiLib.NewManager()
+//	manager.SetDefaults(apiLib.InstanceOptions{APIKey: "yourapikey"})
+//	results := manager.ForEach(ctx, []string{"instanceA", "instanceB"}, func(ctx context.Context, instanceID string, conn *apiLib.XmlmcInstStruct) (interface{}, error) {
+//		return conn.Invoke("system", "pingCheck")
+//	})
+type Manager struct {
+	mu          sync.Mutex
+	client      *http.Client
+	defaults    InstanceOptions
+	options     map[string]InstanceOptions
+	instances   map[string]*managedInstance
+	parallelism int
+	lookup      time.Duration
+}
+
+// managedInstance is a cached connection, ready is closed once the connection has been created
+type managedInstance struct {
+	ready chan struct{}
+	conn  *XmlmcInstStruct
+	err   error
+	slots chan struct{}
+}
+
+// NewManager creates a Manager whose connections share one transport and connection pool.
+// The shared client has no timeout of its own, calls are limited by the timeout of each connection
+// and zone info lookups by the lookup timeout
+// manager := apiLib.NewManager()
+func NewManager() *Manager {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &Manager{
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}},
+		options:     make(map[string]InstanceOptions),
+		instances:   make(map[string]*managedInstance),
+		parallelism: defaultParallelism,
+		lookup:      defaultLookupTimeout,
+	}
+}
+
+// SetHTTPClient sets the client shared by the zone info lookups and every connection created after it is set
+// manager.SetHTTPClient(yourClient)
+func (m *Manager) SetHTTPClient(client *http.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = client
+}
+
+// SetDefaults sets the options used for instances without their own options
+// manager.SetDefaults(apiLib.InstanceOptions{APIKey: "yourapikey", Timeout: time.Minute})
+func (m *Manager) SetDefaults(opts InstanceOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults = opts
+}
+
+// SetInstanceOptions sets the credentials and limits of one instance, replacing any cached connection to it
+// manager.SetInstanceOptions("instanceA", apiLib.InstanceOptions{APIKey: "instanceAkey"})
+func (m *Manager) SetInstanceOptions(instanceID string, opts InstanceOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.options[instanceID] = opts
+	delete(m.instances, instanceID)
+}
+
+// SetLookupTimeout sets how long the zone info lookup made when a connection is created may take, it defaults to 30 seconds
+// manager.SetLookupTimeout(10 * time.Second)
+func (m *Manager) SetLookupTimeout(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d <= 0 {
+		d = defaultLookupTimeout
+	}
+	m.lookup = d
+}
+
+// SetParallelism sets how many instances ForEach runs at once, it defaults to 8
+// manager.SetParallelism(32)
+func (m *Manager) SetParallelism(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	m.parallelism = n
+}
+
+// Get returns the connection to an instance, creating it on first use.
+// Concurrent calls for the same instance wait for one zone info lookup, a failed lookup is not cached
+// conn, err := manager.Get("instanceA")
+func (m *Manager) Get(instanceID string) (*XmlmcInstStruct, error) {
+	instance, err := m.instance(context.Background(), instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", instanceID, err)
+	}
+	return instance.conn, nil
+}
+
+// instance returns the cached connection to an instance, starting its creation if there is none.
+// The connection is created apart from ctx, which only limits how long this caller waits for it,
+// so one caller giving up does not fail the others waiting on the same lookup
+func (m *Manager) instance(ctx context.Context, instanceID string) (*managedInstance, error) {
+	if instanceID == "" {
+		return nil, ErrInstanceIDRequired
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	instance, ok := m.instances[instanceID]
+	if !ok {
+		opts, ok := m.options[instanceID]
+		if !ok {
+			opts = m.defaults
+		}
+		instance = &managedInstance{ready: make(chan struct{})}
+		m.instances[instanceID] = instance
+		go m.create(instanceID, instance, opts, m.client, m.lookup)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-instance.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if instance.err != nil {
+		return nil, instance.err
+	}
+	return instance, nil
+}
+
+// create creates the connection of a managed instance within the lookup timeout and marks it ready
+func (m *Manager) create(instanceID string, instance *managedInstance, opts InstanceOptions, client *http.Client, lookup time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookup)
+	defer cancel()
+	instance.conn, instance.err = newManagedConn(ctx, instanceID, opts, client)
+	if instance.err != nil {
+		m.mu.Lock()
+		if m.instances[instanceID] == instance {
+			delete(m.instances, instanceID)
+		}
+		m.mu.Unlock()
+	} else {
+		maxConcurrent := opts.MaxConcurrent
+		if maxConcurrent < 1 {
+			maxConcurrent = 1
+		}
+		instance.slots = make(chan struct{}, maxConcurrent)
+	}
+	close(instance.ready)
+}
+
+// newManagedConn creates a connection with the options applied, looking up the zone info within ctx
+func newManagedConn(ctx context.Context, instanceID string, opts InstanceOptions, client *http.Client) (*XmlmcInstStruct, error) {
+	servername := instanceID
+	if opts.Endpoint != "" {
+		servername = opts.Endpoint
+	}
+	conn := newXmlmcInstance(ctx, servername, client)
+	if conn.FileError != nil {
+		return nil, conn.FileError
+	}
+	conn.sharedClient = client != nil
+	if conn.server == "" {
+		return nil, ErrNoEndpoint
+	}
+	if conn.instanceID == "" {
+		conn.instanceID = instanceID
+	}
+	conn.SetAPIKey(opts.APIKey)
+	if opts.SessionID != "" {
+		conn.SetSessionID(opts.SessionID)
+	}
+	if opts.Timeout > 0 {
+		conn.SetTimeoutDuration(opts.Timeout)
+	}
+	if opts.Configure != nil {
+		if err := opts.Configure(conn); err != nil {
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Preload creates the connections to the instances, looking up their zone info concurrently.
+// The errors of any instances that could not be created are returned joined together
+// err := manager.Preload("instanceA", "instanceB")
+func (m *Manager) Preload(instanceIDs ...string) error {
+	errs := make([]error, len(instanceIDs))
+	var wg sync.WaitGroup
+	for i, instanceID := range instanceIDs {
+		wg.Add(1)
+		go func(i int, instanceID string) {
+			defer wg.Done()
+			if _, err := m.instance(context.Background(), instanceID); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", instanceID, err)
+			}
+		}(i, instanceID)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Instances returns the IDs of the instances with a cached connection
+func (m *Manager) Instances() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.instances))
+	for instanceID := range m.instances {
+		ids = append(ids, instanceID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Remove drops the cached connection to an instance so the next Get creates a new one
+// manager.Remove("instanceA")
+func (m *Manager) Remove(instanceID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.instances, instanceID)
+}
+
+// Do runs fn with the connection to an instance within the MaxConcurrent limit of the instance
+// err := manager.Do(ctx, "instanceA", func(ctx context.Context, conn *apiLib.XmlmcInstStruct) error { ... })
+func (m *Manager) Do(ctx context.Context, instanceID string, fn func(ctx context.Context, conn *XmlmcInstStruct) error) error {
+	instance, err := m.instance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	select {
+	case instance.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-instance.slots }()
+	return fn(ctx, instance.conn)
+}
+
+// ForEach runs fn against each instance in parallel, up to the parallelism of the Manager,
+// and returns the value and error of each in the order the instances were given.
+// Instances not started before ctx is done are given the error of ctx
+// results := manager.ForEach(ctx, instanceIDs, fn)
+func (m *Manager) ForEach(ctx context.Context, instanceIDs []string, fn func(ctx context.Context, instanceID string, conn *XmlmcInstStruct) (interface{}, error)) Results {
+	m.mu.Lock()
+	parallelism := m.parallelism
+	m.mu.Unlock()
+
+	results := make(Results, len(instanceIDs))
+	workers := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, instanceID := range instanceIDs {
+		results[i].InstanceID = instanceID
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(result *InstanceResult) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			start := time.Now()
+			result.Err = m.Do(ctx, result.InstanceID, func(ctx context.Context, conn *XmlmcInstStruct) error {
+				var err error
+				result.Value, err = fn(ctx, result.InstanceID, conn)
+				return err
+			})
+			result.Duration = time.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
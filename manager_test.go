@@ -0,0 +1,215 @@
+package apiLib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newManagerClient returns a client answering zone info lookups for any instance except "missing"
+// and xmlmc calls with the instance and API key of the request, counting the lookups made
+func newManagerClient() (*http.Client, *atomic.Int64) {
+	lookups := &atomic.Int64{}
+	return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		status, body := http.StatusOK, ""
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/zoneinfo"):
+			lookups.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			body = `{"zoneinfo":{"apiEndpoint":"https://api.example.test/` + parts[1] + `/xmlmc/"}}`
+			if parts[1] == "missing" {
+				status, body = http.StatusNotFound, `{}`
+			}
+		default:
+			body = `<methodCallResult status="ok"><params><instance>` + req.URL.Host + "/" + parts[0] + `</instance><key>` +
+				req.Header.Get("Authorization") + `</key></params></methodCallResult>`
+		}
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{},
+			Body: io.NopCloser(bytes.NewBufferString(body)), Request: req}, nil
+	})}, lookups
+}
+
+func TestManagerGet(t *testing.T) {
+	client, lookups := newManagerClient()
+	manager := NewManager()
+	manager.SetHTTPClient(client)
+	manager.SetDefaults(InstanceOptions{APIKey: "defaultkey", Timeout: time.Minute})
+	manager.SetInstanceOptions("other", InstanceOptions{APIKey: "otherkey"})
+
+	var wg sync.WaitGroup
+	conns := make([]*XmlmcInstStruct, 10)
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], _ = manager.Get("first")
+		}(i)
+	}
+	wg.Wait()
+	for _, conn := range conns {
+		if conn == nil || conn != conns[0] {
+			t.Fatalf("Was expecting one cached connection")
+		}
+	}
+	if lookups.Load() != 1 {
+		t.Errorf("Was expecting 1 zone info lookup but got %d", lookups.Load())
+	}
+	if conns[0].apiKey != "defaultkey" || conns[0].timeout != time.Minute || conns[0].server != "https://api.example.test/first/xmlmc/" {
+		t.Errorf("Defaults not applied %s %s %s", conns[0].apiKey, conns[0].timeout, conns[0].server)
+	}
+
+	other, err := manager.Get("other")
+	if err != nil || other.apiKey != "otherkey" {
+		t.Errorf("Instance options not applied %v", err)
+	}
+	if _, err := manager.Get("missing"); !IsNotFound(err) || !strings.HasPrefix(err.Error(), "missing: ") {
+		t.Errorf("Was expecting a lookup error for missing got %v", err)
+	}
+	if got := strings.Join(manager.Instances(), ","); got != "first,other" {
+		t.Errorf("Was expecting first,other but got %s", got)
+	}
+
+	//-- Lookups for different instances run at the same time
+	lookups.Store(0)
+	start := time.Now()
+	if err := manager.Preload("a", "b", "c", "d", "e", "f"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups.Load() != 6 || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Was expecting 6 concurrent lookups, got %d in %s", lookups.Load(), time.Since(start))
+	}
+	if err := manager.Preload("a", "missing"); err == nil || !strings.Contains(err.Error(), "missing: ") {
+		t.Errorf("Was expecting the missing instance in the error, got %v", err)
+	}
+}
+
+func TestManagerForEach(t *testing.T) {
+	client, _ := newManagerClient()
+	manager := NewManager()
+	manager.SetHTTPClient(client)
+	manager.SetParallelism(2)
+	manager.SetDefaults(InstanceOptions{APIKey: "key"})
+	manager.SetInstanceOptions("direct", InstanceOptions{Endpoint: "https://direct.example.test/xmlmc/"})
+
+	var running, maxRunning atomic.Int64
+	results := manager.ForEach(context.Background(), []string{"one", "missing", "direct", "two", "fails"}, func(ctx context.Context, instanceID string, conn *XmlmcInstStruct) (interface{}, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			max := maxRunning.Load()
+			if n <= max || maxRunning.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		if instanceID == "fails" {
+			return nil, errors.New("failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return conn.Invoke("system", "pingCheck")
+	})
+	if len(results) != 5 || results[0].InstanceID != "one" || results[4].InstanceID != "fails" {
+		t.Fatalf("Results not in order %v", results)
+	}
+	if !strings.Contains(results[0].Value.(string), "<instance>api.example.test/one</instance><key>ESP-APIKEY key</key>") {
+		t.Errorf("Unexpected value %v", results[0].Value)
+	}
+	if !strings.Contains(results[2].Value.(string), "<instance>direct.example.test/xmlmc</instance>") {
+		t.Errorf("Was expecting the endpoint to be used %v", results[2].Value)
+	}
+	if maxRunning.Load() > 2 {
+		t.Errorf("Was expecting at most 2 instances at once but got %d", maxRunning.Load())
+	}
+	failed := results.Failed()
+	if len(failed) != 2 || failed[0].InstanceID != "missing" || failed[1].InstanceID != "fails" {
+		t.Errorf("Unexpected failures %v", failed)
+	}
+	if err := results.Err(); err == nil || !strings.Contains(err.Error(), "fails: failed") {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = manager.ForEach(ctx, []string{"one", "two"}, func(ctx context.Context, instanceID string, conn *XmlmcInstStruct) (interface{}, error) {
+		return nil, nil
+	})
+	if !errors.Is(results.Err(), context.Canceled) {
+		t.Errorf("Was expecting context.Canceled got %v", results.Err())
+	}
+}
+
+func TestManagerLookupHangs(t *testing.T) {
+	manager := NewManager()
+	manager.SetHTTPClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})})
+	manager.SetLookupTimeout(200 * time.Millisecond)
+
+	//-- Callers waiting on a lookup give up with their context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := manager.Do(ctx, "slow", func(ctx context.Context, conn *XmlmcInstStruct) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 150*time.Millisecond {
+		t.Errorf("Was expecting Do to give up with its context got %v after %s", err, time.Since(start))
+	}
+
+	//-- The lookup itself is bounded by the lookup timeout
+	start = time.Now()
+	if _, err := manager.Get("slow"); err == nil || time.Since(start) > time.Second {
+		t.Errorf("Was expecting the lookup to time out got %v after %s", err, time.Since(start))
+	}
+	if len(manager.Instances()) != 0 {
+		t.Errorf("Was not expecting a failed lookup to be cached %v", manager.Instances())
+	}
+}
+
+func TestManagerConfigureTransport(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+		fmt.Fprint(w, `<methodCallResult status="ok"><params><instance>proxy</instance></params></methodCallResult>`)
+	}))
+	defer proxy.Close()
+
+	client, _ := newManagerClient()
+	manager := NewManager()
+	manager.SetHTTPClient(client)
+	manager.SetInstanceOptions("proxied", InstanceOptions{Endpoint: "http://api.example.test/proxied/xmlmc/", Configure: func(conn *XmlmcInstStruct) error {
+		return conn.SetProxy(proxy.URL)
+	}})
+
+	//-- A proxy set in Configure gives the connection its own transport
+	err := manager.Do(context.Background(), "proxied", func(ctx context.Context, conn *XmlmcInstStruct) error {
+		result, err := conn.Invoke("system", "pingCheck")
+		if err == nil && !strings.Contains(result, "<instance>proxy</instance>") {
+			err = fmt.Errorf("unexpected result %s", result)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := proxied.Load().(string); !strings.HasPrefix(got, "http://api.example.test/proxied/xmlmc/") {
+		t.Errorf("Was expecting the call to go through the proxy got %q", got)
+	}
+
+	//-- Other connections keep the shared client
+	conn, err := manager.Get("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := conn.Invoke("system", "pingCheck"); err != nil || !strings.Contains(result, "api.example.test/shared") {
+		t.Errorf("Was expecting the shared client to be used %s %v", result, err)
+	}
+}
@@ -0,0 +1,101 @@
+package apiLib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Environment variables that override the values of the selected profile
+const (
+	EnvConfig   = "HORNBILL_CONFIG"
+	EnvProfile  = "HORNBILL_PROFILE"
+	EnvInstance = "HORNBILL_INSTANCE"
+	EnvAPIKey   = "HORNBILL_APIKEY"
+	EnvProxy    = "HORNBILL_PROXY"
+	EnvTimeout  = "HORNBILL_TIMEOUT"
+)
+
+// Profile holds the settings to connect to one instance, the profiles package loads them from config files
+type Profile struct {
+	//-- Instance is an instance ID or an xmlmc URL
+	Instance   string   `json:"instance"`
+	APIKey     string   `json:"apiKey"`
+	APIKeyFile string   `json:"apiKeyFile"`
+	Proxy      string   `json:"proxy"`
+	NoProxy    []string `json:"noProxy"`
+	//-- Timeout is a duration such as 45s or 2m
+	Timeout      string `json:"timeout"`
+	UserAgent    string `json:"userAgent"`
+	RootCAFile   string `json:"rootCAFile"`
+	Compression  bool   `json:"compression"`
+	JSONResponse bool   `json:"jsonResponse"`
+}
+
+// NewInstance builds an XmlmcInstance with the settings of the profile,
+// the instance is looked up first so zone info errors are returned rather than left in FileError
+// conn, err := profile.NewInstance()
+func (p Profile) NewInstance() (*XmlmcInstStruct, error) {
+	if p.Instance == "" {
+		return nil, fmt.Errorf("%w: no instance in profile, set instance or %s", ErrConfig, EnvInstance)
+	}
+	var timeout time.Duration
+	if p.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return nil, fmt.Errorf("%w: timeout: %w", ErrConfig, err)
+		}
+	}
+	apiKey := p.APIKey
+	if apiKey == "" && p.APIKeyFile != "" {
+		key, err := os.ReadFile(expandHome(p.APIKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+		apiKey = strings.TrimSpace(string(key))
+	}
+
+	//-- The zone info lookup goes through the proxy too, then the instance uses its own transport
+	//-- The proxy and CA apply to the zone info lookup too so are set before it
+	conn, err := NewXmlmcInstanceConfigured(p.Instance, func(conn *XmlmcInstStruct) error {
+		if err := conn.SetProxy(p.Proxy); err != nil {
+			return fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+		if len(p.NoProxy) > 0 {
+			conn.SetNoProxy(p.NoProxy...)
+		}
+		if p.RootCAFile != "" {
+			if err := conn.SetRootCAFile(expandHome(p.RootCAFile)); err != nil {
+				return err
+			}
+		}
+		if timeout > 0 {
+			conn.SetTimeoutDuration(timeout)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.UserAgent != "" {
+		conn.SetUserAgent(p.UserAgent)
+	}
+	conn.SetAPIKey(apiKey)
+	conn.SetCompression(p.Compression)
+	conn.SetJSONResponse(p.JSONResponse)
+	return conn, nil
+}
+
+// expandHome replaces a leading ~ with the home directory of the user
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,11 @@
+module github.com/hornbill/goApiLib/profiles
+
+go 1.20
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/hornbill/goApiLib v1.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace github.com/hornbill/goApiLib => ..
@@ -0,0 +1,176 @@
+// Package profiles loads named apiLib profiles from yaml, toml or json config files,
+// keeping the file formats out of the apiLib package.
+//
+//	cfg, err := profiles.LoadConfig("hornbill.yaml")
+//	conn, err := cfg.NewInstance("prod")
+package profiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	apiLib "github.com/hornbill/goApiLib"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a set of named profiles loaded with LoadConfig
+//
+//	default: dev
+//	profiles:
+//	  dev:
+//	    instance: yourdevinstance
+//	    apiKeyFile: ~/.hornbill/dev.key
+//	  prod:
+//	    instance: https://eurapi.hornbill.com/yourinstance/xmlmc/
+//	    proxy: http://proxy.internal:3128
+//	    timeout: 2m
+type Config struct {
+	Default  string                    `json:"default"`
+	Profiles map[string]apiLib.Profile `json:"profiles"`
+
+	getenv func(string) string
+}
+
+// LoadConfig reads profiles from a yaml, toml or json file depending on its extension
+// cfg, err := profiles.LoadConfig("hornbill.yaml")
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", apiLib.ErrConfig, err)
+	}
+	//-- apiLib.Profile only carries json tags, so yaml and toml are read generically and converted to json
+	var document map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	case ".json":
+	default:
+		return nil, fmt.Errorf("%w: %s must be .yaml, .yml, .toml or .json", apiLib.ErrConfig, path)
+	}
+	if err == nil && document != nil {
+		data, err = json.Marshal(document)
+	}
+	cfg := &Config{}
+	if err == nil {
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", apiLib.ErrConfig, path, err)
+	}
+	return cfg, nil
+}
+
+// SetGetenv sets the function used to read the HORNBILL_ environment overrides, os.Getenv when nil
+// cfg.SetGetenv(func(key string) string { return env[key] })
+func (cfg *Config) SetGetenv(getenv func(string) string) {
+	cfg.getenv = getenv
+}
+
+// LoadDefaultConfig loads the file named by HORNBILL_CONFIG, or the first of config.yaml, config.yml,
+// config.toml and config.json in the hornbill directory of the user config directory.
+// If there is no config file an empty Config is returned so profiles can come from the environment alone.
+// The environment is read with getenv, which is kept for the profile overrides, os.Getenv when nil
+// cfg, err := profiles.LoadDefaultConfig(os.Getenv)
+func LoadDefaultConfig(getenv func(string) string) (*Config, error) {
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	cfg, err := loadDefaultConfig(getenv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.getenv = getenv
+	return cfg, nil
+}
+
+// loadDefaultConfig finds and loads the default config file
+func loadDefaultConfig(getenv func(string) string) (*Config, error) {
+	if path := getenv(apiLib.EnvConfig); path != "" {
+		return LoadConfig(path)
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return &Config{}, nil
+	}
+	for _, name := range []string{"config.yaml", "config.yml", "config.toml", "config.json"} {
+		path := filepath.Join(dir, "hornbill", name)
+		if _, err := os.Stat(path); err == nil {
+			return LoadConfig(path)
+		}
+	}
+	return &Config{}, nil
+}
+
+// ProfileNames returns the names of the profiles in the config in order
+func (cfg *Config) ProfileNames() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the named profile with the environment overrides applied.
+// An empty name selects HORNBILL_PROFILE and then the default profile of the config,
+// if neither is set the profile is built from the environment alone
+// profile, err := cfg.Profile("prod")
+func (cfg *Config) Profile(name string) (apiLib.Profile, error) {
+	getenv := cfg.getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	if name == "" {
+		name = getenv(apiLib.EnvProfile)
+	}
+	if name == "" {
+		name = cfg.Default
+	}
+	var profile apiLib.Profile
+	if name != "" {
+		var ok bool
+		if profile, ok = cfg.Profiles[name]; !ok {
+			return apiLib.Profile{}, fmt.Errorf("%w: %s", apiLib.ErrProfileNotFound, name)
+		}
+	}
+	if v := getenv(apiLib.EnvInstance); v != "" {
+		profile.Instance = v
+	}
+	if v := getenv(apiLib.EnvAPIKey); v != "" {
+		profile.APIKey = v
+	}
+	if v := getenv(apiLib.EnvProxy); v != "" {
+		profile.Proxy = v
+	}
+	if v := getenv(apiLib.EnvTimeout); v != "" {
+		profile.Timeout = v
+	}
+	return profile, nil
+}
+
+// NewInstance builds an XmlmcInstance from the named profile, see Profile for how the name is chosen
+// conn, err := cfg.NewInstance("prod")
+func (cfg *Config) NewInstance(name string) (*apiLib.XmlmcInstStruct, error) {
+	profile, err := cfg.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	return profile.NewInstance()
+}
+
+// NewXmlmcInstanceFromProfile loads the default config and builds an XmlmcInstance from the named profile
+// conn, err := profiles.NewXmlmcInstanceFromProfile("dev")
+func NewXmlmcInstanceFromProfile(name string) (*apiLib.XmlmcInstStruct, error) {
+	cfg, err := LoadDefaultConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.NewInstance(name)
+}
@@ -0,0 +1,136 @@
+package profiles
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apiLib "github.com/hornbill/goApiLib"
+)
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `default: dev
+profiles:
+  dev:
+    instance: http://127.0.0.1/dev/xmlmc/
+    apiKey: devkey
+    noProxy: [localhost, .internal]
+  prod:
+    instance: http://127.0.0.1/prod/xmlmc/
+    proxy: http://proxy.internal:3128
+    timeout: 2m
+`,
+		"config.toml": `default = "dev"
+[profiles.dev]
+instance = "http://127.0.0.1/dev/xmlmc/"
+apiKey = "devkey"
+noProxy = ["localhost", ".internal"]
+[profiles.prod]
+instance = "http://127.0.0.1/prod/xmlmc/"
+proxy = "http://proxy.internal:3128"
+timeout = "2m"
+`,
+		"config.json": `{"default":"dev","profiles":{
+"dev":{"instance":"http://127.0.0.1/dev/xmlmc/","apiKey":"devkey","noProxy":["localhost",".internal"]},
+"prod":{"instance":"http://127.0.0.1/prod/xmlmc/","proxy":"http://proxy.internal:3128","timeout":"2m"}}}`,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.getenv = func(string) string { return "" }
+			if names := cfg.ProfileNames(); len(names) != 2 || names[0] != "dev" || names[1] != "prod" {
+				t.Errorf("unexpected profiles %v", names)
+			}
+			dev, err := cfg.Profile("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dev.APIKey != "devkey" || len(dev.NoProxy) != 2 {
+				t.Errorf("unexpected default profile %+v", dev)
+			}
+			prod, err := cfg.Profile("prod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prod.Instance != "http://127.0.0.1/prod/xmlmc/" || prod.Proxy != "http://proxy.internal:3128" || prod.Timeout != "2m" {
+				t.Errorf("unexpected prod profile %+v", prod)
+			}
+			conn, err := cfg.NewInstance("prod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conn.GetServerURL() != prod.Instance {
+				t.Errorf("profile not applied server %s", conn.GetServerURL())
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "config.ini")); !errors.Is(err, apiLib.ErrConfig) {
+		t.Errorf("expected ErrConfig got %v", err)
+	}
+}
+
+func TestProfileEnvironment(t *testing.T) {
+	env := map[string]string{}
+	cfg := &Config{
+		Profiles: map[string]apiLib.Profile{"test": {Instance: "http://127.0.0.1/test/xmlmc/", APIKey: "key"}},
+		getenv:   func(key string) string { return env[key] },
+	}
+
+	if _, err := cfg.Profile("missing"); !errors.Is(err, apiLib.ErrProfileNotFound) {
+		t.Errorf("expected ErrProfileNotFound got %v", err)
+	}
+	if _, err := cfg.NewInstance(""); !errors.Is(err, apiLib.ErrConfig) {
+		t.Errorf("expected ErrConfig without an instance got %v", err)
+	}
+
+	env[apiLib.EnvProfile] = "test"
+	profile, err := cfg.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Instance != "http://127.0.0.1/test/xmlmc/" || profile.APIKey != "key" {
+		t.Errorf("expected the profile named by the environment got %+v", profile)
+	}
+
+	env[apiLib.EnvInstance] = "http://127.0.0.1/other/xmlmc/"
+	env[apiLib.EnvAPIKey] = "envkey"
+	env[apiLib.EnvProxy] = "http://proxy.internal:3128"
+	env[apiLib.EnvTimeout] = "5s"
+	profile, err = cfg.Profile("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Instance != env[apiLib.EnvInstance] || profile.APIKey != "envkey" || profile.Proxy != env[apiLib.EnvProxy] || profile.Timeout != "5s" {
+		t.Errorf("environment not applied %+v", profile)
+	}
+}
+
+func TestLoadDefaultConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hornbill.yaml")
+	if err := os.WriteFile(path, []byte("default: dev\nprofiles:\n  dev:\n    instance: http://127.0.0.1/dev/xmlmc/\n    rootCAFile: ca.pem\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{apiLib.EnvConfig: path, apiLib.EnvAPIKey: "envkey"}
+	cfg, err := LoadDefaultConfig(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := cfg.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Instance != "http://127.0.0.1/dev/xmlmc/" || profile.RootCAFile != "ca.pem" || profile.APIKey != "envkey" {
+		t.Errorf("Was expecting the config and overrides from getenv but got %+v", profile)
+	}
+}
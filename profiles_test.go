@@ -0,0 +1,43 @@
+package apiLib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfileNewInstance(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api.key")
+	if err := os.WriteFile(keyFile, []byte("filekey\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (Profile{}).NewInstance(); !errors.Is(err, ErrConfig) {
+		t.Errorf("expected ErrConfig without an instance got %v", err)
+	}
+
+	profile := Profile{Instance: "http://127.0.0.1/test/xmlmc/", APIKeyFile: keyFile, JSONResponse: true}
+	conn, err := profile.NewInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.apiKey != "filekey" || !conn.jsonresp {
+		t.Errorf("expected the key from the file and json responses got %q %v", conn.apiKey, conn.jsonresp)
+	}
+
+	profile = Profile{Instance: "http://127.0.0.1/prod/xmlmc/", APIKey: "key", Proxy: "http://proxy.internal:3128", Timeout: "2m"}
+	conn, err = profile.NewInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.server != "http://127.0.0.1/prod/xmlmc/" || conn.apiKey != "key" || conn.timeout != 2*time.Minute || conn.proxyURL == nil || conn.proxyURL.Host != "proxy.internal:3128" {
+		t.Errorf("profile not applied server %s key %s timeout %s proxy %v", conn.server, conn.apiKey, conn.timeout, conn.proxyURL)
+	}
+
+	profile.Timeout = "soon"
+	if _, err := profile.NewInstance(); !errors.Is(err, ErrConfig) {
+		t.Errorf("expected ErrConfig for an invalid timeout got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package apiLib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// roundTripperFunc allows a function to be used as an http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSetProxy(t *testing.T) {
+	var proxied, proxyAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer direct.Close()
+
+	conn := NewXmlmcInstance("http://api.example.test/instance/xmlmc/")
+	if err := conn.SetProxy("://bad"); !errors.Is(err, ErrInvalidProxy) {
+		t.Errorf("Was expecting an error for an invalid proxy URL")
+	}
+	_ = conn.SetProxy(strings.Replace(proxy.URL, "http://", "http://user:secret@", 1))
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(proxied, "http://api.example.test/instance/xmlmc/") || proxyAuth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Was expecting the request to go through the proxy with credentials but got %s %s", proxied, proxyAuth)
+	}
+
+	proxied = ""
+	conn = NewXmlmcInstance(direct.URL)
+	_ = conn.SetProxy(proxy.URL)
+	conn.SetNoProxy("localhost", "127.0.0.0/8")
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != "" {
+		t.Errorf("Was expecting a direct connection but the proxy was used for %s", proxied)
+	}
+}
+
+func TestNewXmlmcInstanceConfigured(t *testing.T) {
+	var tunnels []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tunnels = append(tunnels, r.Method+" "+r.Host)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	//-- The zone info lookup goes through the proxy set before it
+	_, err := NewXmlmcInstanceConfigured("test", func(conn *XmlmcInstStruct) error {
+		return conn.SetProxy(proxy.URL)
+	})
+	if err == nil || len(tunnels) != 2 || tunnels[0] != "CONNECT files.hornbill.com:443" || tunnels[1] != "CONNECT files.hornbill.co:443" {
+		t.Errorf("Was expecting both zone info lookups to go through the proxy %v %v", tunnels, err)
+	}
+
+	configureErr := errors.New("configure failed")
+	if _, err := NewXmlmcInstanceConfigured("test", func(conn *XmlmcInstStruct) error { return configureErr }); err != configureErr {
+		t.Errorf("Was expecting the configure error got %v", err)
+	}
+	conn, err := NewXmlmcInstanceConfigured(proxy.URL+"/xmlmc/", nil)
+	if err != nil || conn.GetServerURL() != proxy.URL+"/xmlmc/" {
+		t.Errorf("Was expecting a url to be used as is %v", err)
+	}
+}
+
+var noProxyTests = []struct {
+	host  string
+	match bool
+}{
+	{"hornbill.internal", true},
+	{"api.hornbill.internal", true},
+	{"nothornbill.internal", false},
+	{"api.corp", true},
+	{"corp", false},
+	{"10.1.2.3", true},
+	{"11.1.2.3", false},
+}
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := []string{"hornbill.internal", ".corp", "10.0.0.0/8", ""}
+	for _, tt := range noProxyTests {
+		if matchNoProxy(noProxy, tt.host) != tt.match {
+			t.Errorf("for: %s was expecting match %t", tt.host, tt.match)
+		}
+	}
+	if !matchNoProxy([]string{"*"}, "anything") {
+		t.Errorf("Was expecting * to match every host")
+	}
+}
+
+func TestNewXmlmcInstanceWithClient(t *testing.T) {
+	var hosts []string
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		body := `<methodCallResult status="ok"><params/></methodCallResult>`
+		if strings.HasSuffix(req.URL.Path, "/zoneinfo") {
+			body = `{"zoneinfo":{"name":"test","apiEndpoint":"https://api.example.test/test/xmlmc/","davEndpoint":"https://api.example.test/test/dav/","releaseStream":"beta"}}`
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})}
+
+	conn := NewXmlmcInstanceWithClient("test", client)
+	if conn.FileError != nil {
+		t.Fatal(conn.FileError)
+	}
+	if conn.GetServerURL() != "https://api.example.test/test/xmlmc/" || conn.GetServerStream() != "beta" {
+		t.Errorf("Unexpected zone info %s %s", conn.GetServerURL(), conn.GetServerStream())
+	}
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := conn.DavRequest("GET", "file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if strings.Join(hosts, ",") != "files.hornbill.com,api.example.test,api.example.test" {
+		t.Errorf("Was expecting every request to use the client but got %v", hosts)
+	}
+
+	called := false
+	conn = NewXmlmcInstance("https://api.example.test/test/xmlmc/")
+	conn.SetRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		called = true
+		return client.Transport.RoundTrip(req)
+	}))
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil || !called {
+		t.Errorf("Was expecting the round tripper to be used %v", err)
+	}
+}
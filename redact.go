@@ -0,0 +1,224 @@
+package apiLib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultRedactedParams are the param names whose values are redacted when an instance has not set its own
+// with SetRedactedParams. A param is redacted when its name contains one of them, ignoring case
+var DefaultRedactedParams = []string{"password", "apiKey", "token", "secret", "credential", "privateKey"}
+
+// redactedHeaders are the headers whose values are redacted, the scheme of an authorization is kept
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// SetRedactedParams replaces the default list of sensitive param names whose values are redacted from
+// GetParam, dry runs, debug output and logs. A param is redacted when its name contains one of the names,
+// ignoring case. Params set with SetParamSecret are always redacted
+// conn.SetRedactedParams("password", "h_pin")
+func (xmlmc *XmlmcInstStruct) SetRedactedParams(names ...string) {
+	xmlmc.redactList = append([]string{}, names...)
+}
+
+// AddRedactedParams adds param names to be redacted to the defaults or the names set with SetRedactedParams
+// conn.AddRedactedParams("h_pin")
+func (xmlmc *XmlmcInstStruct) AddRedactedParams(names ...string) {
+	if xmlmc.redactNames == nil {
+		xmlmc.redactNames = make(map[string]bool)
+	}
+	for _, name := range names {
+		xmlmc.redactNames[strings.ToLower(name)] = true
+	}
+}
+
+// isRedacted returns true if the value of the param must not be shown
+func (xmlmc *XmlmcInstStruct) isRedacted(name string) bool {
+	lower := strings.ToLower(strings.TrimPrefix(name, "@"))
+	if xmlmc.redactNames[lower] {
+		return true
+	}
+	list := xmlmc.redactList
+	if list == nil {
+		list = DefaultRedactedParams
+	}
+	for _, sensitive := range list {
+		if sensitive != "" && strings.Contains(lower, strings.ToLower(sensitive)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns an xml or json methodCall, methodCallResult or params with the values of sensitive params
+// redacted so it can be logged or recorded. Json that can not be parsed is redacted completely
+// log.Println(conn.Redact(requestBody))
+func (xmlmc *XmlmcInstStruct) Redact(body string) string {
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		redacted, err := redactJSON([]byte(trimmed), xmlmc.isRedacted)
+		if err != nil {
+			return redactedValue
+		}
+		return string(redacted)
+	}
+	return redactParams(body, xmlmc.isRedacted)
+}
+
+// RedactHeader returns a copy of h with the credentials and session cookies redacted
+// log.Println(apiLib.RedactHeader(resp.Header))
+func RedactHeader(h http.Header) http.Header {
+	redacted := h.Clone()
+	for _, name := range redactedHeaders {
+		values := redacted.Values(name)
+		for i, value := range values {
+			if scheme, _, found := strings.Cut(value, " "); found && strings.HasSuffix(name, "Authorization") {
+				values[i] = scheme + " " + redactedValue
+				continue
+			}
+			values[i] = redactedValue
+		}
+	}
+	return redacted
+}
+
+// SetDebugWriter sets a writer that every request and the status and headers of every response are dumped to,
+// with the API key, session and sensitive params redacted. nil stops the dump
+// conn.SetDebugWriter(os.Stderr)
+func (xmlmc *XmlmcInstStruct) SetDebugWriter(w io.Writer) {
+	xmlmc.debugWriter = w
+}
+
+// debugRequest dumps the request about to be sent for the call
+func (xmlmc *XmlmcInstStruct) debugRequest(req *http.Request, call *methodCall) {
+	if xmlmc.debugWriter == nil {
+		return
+	}
+	dump := &DryRunRequest{Method: req.Method, URL: req.URL.String(), Header: RedactHeader(req.Header), Body: xmlmc.redactedBody(call)}
+	io.WriteString(xmlmc.debugWriter, dump.String())
+}
+
+// debugResponse dumps the status and headers of a response, or the error the request failed with
+func (xmlmc *XmlmcInstStruct) debugResponse(resp *http.Response, err error, latency time.Duration) {
+	if xmlmc.debugWriter == nil {
+		return
+	}
+	var buf strings.Builder
+	if err != nil {
+		buf.WriteString("Error after " + latency.String() + ": " + err.Error() + "\n\n")
+		io.WriteString(xmlmc.debugWriter, buf.String())
+		return
+	}
+	buf.WriteString(resp.Proto + " " + resp.Status + " in " + latency.String() + "\n")
+	header := RedactHeader(resp.Header)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			buf.WriteString(name + ": " + value + "\n")
+		}
+	}
+	buf.WriteString("\n")
+	io.WriteString(xmlmc.debugWriter, buf.String())
+}
+
+// debugFailover notes that a call is being sent to the next endpoint after server failed with err
+func (xmlmc *XmlmcInstStruct) debugFailover(server string, err error) {
+	if xmlmc.debugWriter == nil {
+		return
+	}
+	io.WriteString(xmlmc.debugWriter, "Endpoint "+server+" failed, trying the next: "+err.Error()+"\n\n")
+}
+
+// redactedBody returns the body of the call with sensitive params redacted
+func (xmlmc *XmlmcInstStruct) redactedBody(call *methodCall) []byte {
+	return []byte(xmlmc.Redact(string(call.body)))
+}
+
+// redactJSON rewrites json replacing the value of every member whose name matches redact with redactedValue
+func redactJSON(body []byte, redact func(name string) bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var out bytes.Buffer
+	if err := copyJSONValue(decoder, &out, redact); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after json value")
+	}
+	return out.Bytes(), nil
+}
+
+// copyJSONValue copies the next value from decoder to out
+func copyJSONValue(decoder *json.Decoder, out *bytes.Buffer, redact func(name string) bool) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		encoded, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		out.Write(encoded)
+		return nil
+	}
+	switch delim {
+	case '{':
+		out.WriteByte('{')
+		for first := true; decoder.More(); first = false {
+			if !first {
+				out.WriteByte(',')
+			}
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			name, _ := key.(string)
+			encoded, _ := json.Marshal(name)
+			out.Write(encoded)
+			out.WriteByte(':')
+			if redact(name) {
+				if err := skipJSONValue(decoder); err != nil {
+					return err
+				}
+				out.WriteString(`"` + redactedValue + `"`)
+				continue
+			}
+			if err := copyJSONValue(decoder, out, redact); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	case '[':
+		out.WriteByte('[')
+		for first := true; decoder.More(); first = false {
+			if !first {
+				out.WriteByte(',')
+			}
+			if err := copyJSONValue(decoder, out, redact); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	default:
+		return errors.New("unexpected json delimiter")
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// skipJSONValue reads past the next value
+func skipJSONValue(decoder *json.Decoder) error {
+	var discard json.RawMessage
+	return decoder.Decode(&discard)
+}
@@ -0,0 +1,106 @@
+package apiLib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	conn := NewXmlmcInstance("http://127.0.0.1/test/xmlmc/")
+	conn.SetParam("userId", "admin")
+	conn.SetParam("h_password", "plain")
+	conn.SetParam("h_pin", "1234")
+	if conn.GetParam() != "<params><userId>admin</userId><h_password>********</h_password><h_pin>1234</h_pin></params>" {
+		t.Errorf("Was expecting the default names to be redacted %s", conn.GetParam())
+	}
+	conn.AddRedactedParams("H_PIN")
+	if strings.Contains(conn.GetParam(), "1234") {
+		t.Errorf("Was expecting an added name to be redacted %s", conn.GetParam())
+	}
+
+	conn.SetRedactedParams("userId")
+	if conn.GetParam() != "<params><userId>********</userId><h_password>plain</h_password><h_pin>********</h_pin></params>" {
+		t.Errorf("Was expecting only the set and added names to be redacted %s", conn.GetParam())
+	}
+	conn.SetRedactedParams(DefaultRedactedParams...)
+
+	tests := []struct {
+		body, expected string
+	}{
+		{`{"@service":"session","params":{"userId":"admin","password":"UGFzcw==","nested":{"apiKey":["a","b"]}}}`,
+			`{"@service":"session","params":{"userId":"admin","password":"********","nested":{"apiKey":"********"}}}`},
+		{`[{"@secret":1},{"count":2.50}]`, `[{"@secret":"********"},{"count":2.50}]`},
+		{`{"password":`, redactedValue},
+		{`<methodCallResult status="ok"><params><authToken>abc</authToken></params></methodCallResult>`,
+			`<methodCallResult status="ok"><params><authToken>********</authToken></params></methodCallResult>`},
+	}
+	for _, test := range tests {
+		if got := conn.Redact(test.body); got != test.expected {
+			t.Errorf("Redact(%s) expected %s got %s", test.body, test.expected, got)
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "ESP-APIKEY secretkey")
+	h.Set("Cookie", "ESPSessionState=secretsession")
+	h.Add("Set-Cookie", "ESPSessionState=secretsession; Path=/")
+	h.Set("Content-Type", "text/xmlmc")
+	redacted := RedactHeader(h)
+	if redacted.Get("Authorization") != "ESP-APIKEY ********" || redacted.Get("Cookie") != "********" || redacted.Get("Set-Cookie") != "********" || redacted.Get("Content-Type") != "text/xmlmc" {
+		t.Errorf("Unexpected headers %v", redacted)
+	}
+	if h.Get("Authorization") != "ESP-APIKEY secretkey" {
+		t.Errorf("Was not expecting the original headers to change %v", h)
+	}
+}
+
+func TestSetDebugWriter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "ESPSessionState=newsession; Path=/")
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	var sink bytes.Buffer
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	conn.SetAPIKey("secretkey")
+	conn.SetDebugWriter(&sink)
+	conn.SetParam("userId", "admin")
+	conn.SetParamSecret("password", "Password1")
+	if _, err := conn.Invoke("session", "userLogon"); err != nil {
+		t.Fatal(err)
+	}
+	dump := sink.String()
+	if !strings.Contains(dump, "POST "+ts.URL) || !strings.Contains(dump, "<password>********</password>") || !strings.Contains(dump, "200 OK") {
+		t.Errorf("Was expecting the request and response to be dumped %s", dump)
+	}
+	for _, secret := range []string{"secretkey", "newsession", "UGFzc3dvcmQx"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("Was not expecting %s in the dump %s", secret, dump)
+		}
+	}
+}
+
+func TestCreateRequestErrorNotLogged(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	conn := NewXmlmcInstance("http://127.0.0.1:badport/test/xmlmc/")
+	_ = conn.SetParamSecret("password", "Password")
+	if _, err := conn.Invoke("session", "userLogon"); !errors.Is(err, ErrCreateRequest) {
+		t.Errorf("Was expecting ErrCreateRequest but got %v", err)
+	}
+	if logged.Len() > 0 {
+		t.Errorf("Was not expecting the request to be logged but got %s", logged.String())
+	}
+}
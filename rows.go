@@ -0,0 +1,276 @@
+package apiLib
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Row is a single row of a result set keyed by column name
+type Row map[string]string
+
+// RowDecoder reads the rows of an xml or json result set one at a time without buffering the full document
+type RowDecoder struct {
+	xml     *xml.Decoder
+	json    *json.Decoder
+	frames  []jsonFrame
+	inArray bool
+	failed  bool
+	state   *MethodError
+}
+
+// jsonFrame tracks whether the next string in a json object is a member name
+type jsonFrame struct {
+	object bool
+	key    bool
+}
+
+// NewRowDecoder returns a RowDecoder reading from r, which would normally be the body returned by InvokeStream.
+// Rows are the <row> elements of an xml response or the members of "row" in a json response
+// rows := apiLib.NewRowDecoder(body)
+func NewRowDecoder(r io.Reader) *RowDecoder {
+	buffered := bufio.NewReader(r)
+	for {
+		b, err := buffered.Peek(1)
+		if err != nil || !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			if err == nil && (b[0] == '{' || b[0] == '[') {
+				return &RowDecoder{json: json.NewDecoder(buffered)}
+			}
+			return &RowDecoder{xml: xml.NewDecoder(buffered)}
+		}
+		buffered.ReadByte()
+	}
+}
+
+// Next returns the next row, or io.EOF once there are no more rows.
+// If the method returned a status of fail the *MethodError from its state is returned rather than io.EOF,
+// so a failed query is not mistaken for an empty result set
+// for row, err := rows.Next(); err == nil; row, err = rows.Next() {}
+func (d *RowDecoder) Next() (Row, error) {
+	if d.json != nil {
+		return d.nextJSON()
+	}
+	return d.nextXML()
+}
+
+func (d *RowDecoder) nextXML() (Row, error) {
+	for {
+		token, err := d.xml.Token()
+		if err != nil {
+			return nil, d.endError(err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "row":
+			return d.readXMLRow()
+		case "methodCallResult":
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "status" && attr.Value == "fail" {
+					d.failed = true
+				}
+			}
+		case "state":
+			if d.failed {
+				state := &MethodError{}
+				if err := d.xml.DecodeElement(state, &start); err != nil {
+					return nil, err
+				}
+				d.state = state
+				return nil, state
+			}
+		}
+	}
+}
+
+// endError returns the error to give once the response has been read, the MethodError of a failed method or err
+func (d *RowDecoder) endError(err error) error {
+	if err != io.EOF || !d.failed {
+		return err
+	}
+	if d.state == nil {
+		d.state = &MethodError{Message: "Method failed without a state"}
+	}
+	return d.state
+}
+
+// readXMLRow reads the columns of a row, the text of any nested elements is added to its column
+func (d *RowDecoder) readXMLRow() (Row, error) {
+	row := Row{}
+	column := ""
+	depth := 0
+	for {
+		token, err := d.xml.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				column = t.Name.Local
+				row[column] = ""
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return row, nil
+			}
+			depth--
+		case xml.CharData:
+			if depth > 0 {
+				row[column] += string(t)
+			}
+		}
+	}
+}
+
+func (d *RowDecoder) nextJSON() (Row, error) {
+	if d.inArray {
+		if d.json.More() {
+			return d.readJSONRow()
+		}
+		//-- Consume the closing ] of the row array
+		if _, err := d.json.Token(); err != nil {
+			return nil, err
+		}
+		d.inArray = false
+		d.afterValue()
+	}
+	for {
+		token, err := d.json.Token()
+		if err != nil {
+			return nil, d.endError(err)
+		}
+		switch t := token.(type) {
+		case json.Delim:
+			if t == '{' || t == '[' {
+				d.frames = append(d.frames, jsonFrame{object: t == '{', key: t == '{'})
+				continue
+			}
+			d.frames = d.frames[:len(d.frames)-1]
+			d.afterValue()
+		case string:
+			if len(d.frames) > 0 && d.frames[len(d.frames)-1].key {
+				d.frames[len(d.frames)-1].key = false
+				if t == "row" {
+					return d.startJSONRows()
+				}
+				//-- The status and state of the result are members of the outermost object
+				if len(d.frames) == 1 && (t == "@status" || t == "state") {
+					if err := d.readJSONStatus(t); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			d.afterValue()
+		default:
+			d.afterValue()
+		}
+	}
+}
+
+// startJSONRows is called after a "row" member name, which holds either an array of rows or a single row
+func (d *RowDecoder) startJSONRows() (Row, error) {
+	if !d.json.More() {
+		return d.nextJSON()
+	}
+	token, err := d.json.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('['):
+		d.inArray = true
+		return d.nextJSON()
+	case json.Delim('{'):
+		row := Row{}
+		for d.json.More() {
+			name, err := d.json.Token()
+			if err != nil {
+				return nil, err
+			}
+			var value interface{}
+			if err := d.json.Decode(&value); err != nil {
+				return nil, err
+			}
+			row[name.(string)] = jsonString(value)
+		}
+		//-- Consume the closing } of the row
+		if _, err := d.json.Token(); err != nil {
+			return nil, err
+		}
+		d.afterValue()
+		return row, nil
+	}
+	d.afterValue()
+	return d.nextJSON()
+}
+
+// readJSONStatus reads the value of the "@status" or "state" member, returning the MethodError once the method is known to have failed
+func (d *RowDecoder) readJSONStatus(name string) error {
+	if name == "@status" {
+		var status interface{}
+		if err := d.json.Decode(&status); err != nil {
+			return err
+		}
+		d.failed = status == false
+	} else {
+		var raw json.RawMessage
+		if err := d.json.Decode(&raw); err != nil {
+			return err
+		}
+		state := &MethodError{}
+		if json.Unmarshal(raw, state) == nil {
+			d.state = state
+		}
+	}
+	d.afterValue()
+	if d.failed && d.state != nil {
+		return d.state
+	}
+	return nil
+}
+
+func (d *RowDecoder) readJSONRow() (Row, error) {
+	var values map[string]interface{}
+	if err := d.json.Decode(&values); err != nil {
+		return nil, err
+	}
+	row := Row{}
+	for name, value := range values {
+		row[name] = jsonString(value)
+	}
+	return row, nil
+}
+
+// afterValue marks that the next string in the enclosing object is a member name again
+func (d *RowDecoder) afterValue() {
+	if len(d.frames) > 0 && d.frames[len(d.frames)-1].object {
+		d.frames[len(d.frames)-1].key = true
+	}
+}
+
+// jsonString returns a decoded json value as the string it would have been in xml
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
@@ -0,0 +1,96 @@
+package apiLib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var rowDecoderTests = []struct {
+	body string
+	rows []Row
+}{
+	{`<?xml version="1.0" encoding="utf-8" ?><methodCallResult status="ok"><params><rowData>` +
+		`<row><h_id>1</h_id><h_name>Alice &amp; Bob</h_name></row><row><h_id>2</h_id><h_name/></row>` +
+		`</rowData><count>2</count></params></methodCallResult>`,
+		[]Row{{"h_id": "1", "h_name": "Alice & Bob"}, {"h_id": "2", "h_name": ""}}},
+	{` {"@status":true,"params":{"rowData":{"row":[{"h_id":1,"h_name":"Alice"},{"h_id":2,"h_name":null,"h_flag":true}]},"count":2}}`,
+		[]Row{{"h_id": "1", "h_name": "Alice"}, {"h_id": "2", "h_name": "", "h_flag": "true"}}},
+	{`{"@status":true,"params":{"row":"not a key","rowData":{"row":{"h_id":"1"}},"after":{"row":[{"h_id":"2"}]}}}`,
+		[]Row{{"h_id": "1"}, {"h_id": "2"}}},
+	{`{"@status":true,"params":{"rowData":{"row":[]}}}`, nil},
+	{`<methodCallResult status="ok"><params/></methodCallResult>`, nil},
+}
+
+func TestRowDecoder(t *testing.T) {
+	for _, tt := range rowDecoderTests {
+		decoder := NewRowDecoder(strings.NewReader(tt.body))
+		var rows []Row
+		for {
+			row, err := decoder.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("for: %s got error %s\n", tt.body, err)
+			}
+			rows = append(rows, row)
+		}
+		if !reflect.DeepEqual(rows, tt.rows) {
+			t.Errorf("for: %s got: %v but want: %v\n", tt.body, rows, tt.rows)
+		}
+	}
+}
+
+func TestRowDecoderMethodError(t *testing.T) {
+	bodies := []string{
+		`<methodCallResult status="fail"><state><code>0200</code><service>data</service><operation>queryExec</operation><error>Unknown query</error></state></methodCallResult>`,
+		`{"@status":false,"state":{"code":"0200","service":"data","operation":"queryExec","error":"Unknown query"}}`,
+		`{"state":{"code":"0200","service":"data","operation":"queryExec","error":"Unknown query"},"@status":false}`,
+	}
+	for _, body := range bodies {
+		_, err := NewRowDecoder(strings.NewReader(body)).Next()
+		var methodErr *MethodError
+		if !errors.As(err, &methodErr) || methodErr.Code != "0200" || methodErr.Message != "Unknown query" {
+			t.Errorf("for: %s was expecting the method error got %v", body, err)
+		}
+	}
+	if _, err := NewRowDecoder(strings.NewReader(`<methodCallResult status="fail"></methodCallResult>`)).Next(); err == io.EOF || err == nil {
+		t.Errorf("Was expecting a failure without a state not to look like an empty result")
+	}
+}
+
+func TestInvokeStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "ESPSESSION=stream")
+		fmt.Fprint(w, `<methodCallResult status="ok"><params><rowData><row><h_id>1</h_id></row></rowData></params></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	_ = conn.SetParam("queryName", "test")
+	body, err := conn.InvokeStream("data", "queryExec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := NewRowDecoder(body).Next()
+	body.Close()
+	if err != nil || row["h_id"] != "1" {
+		t.Errorf("Unexpected row %v %v\n", row, err)
+	}
+	if conn.GetSessionID() != "ESPSESSION=stream" || conn.GetParam() != "<params></params>" {
+		t.Errorf("Was expecting the session to be set and the params cleared")
+	}
+
+	var buf bytes.Buffer
+	written, err := conn.InvokeToWriter("data", "queryExec", &buf)
+	if err != nil || written != int64(buf.Len()) || !strings.Contains(buf.String(), "<h_id>1</h_id>") {
+		t.Errorf("Unexpected write %d %v %s\n", written, err, buf.String())
+	}
+}
@@ -0,0 +1,61 @@
+package apiLib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+)
+
+// paramStream is a param whose base64 encoded content is read from r when the request is sent
+type paramStream struct {
+	offset int
+	r      io.Reader
+}
+
+// requestBody returns the body of the call with the content of any streamed params encoded in place
+func (call *methodCall) requestBody() io.Reader {
+	if len(call.streams) == 0 {
+		return bytes.NewReader(call.body)
+	}
+	readers := make([]io.Reader, 0, len(call.streams)*2+1)
+	last := 0
+	for _, stream := range call.streams {
+		readers = append(readers, bytes.NewReader(call.body[last:stream.offset]), newBase64Reader(stream.r))
+		last = stream.offset
+	}
+	readers = append(readers, bytes.NewReader(call.body[last:]))
+	return io.MultiReader(readers...)
+}
+
+// base64Reader encodes the content of r as base64 as it is read
+type base64Reader struct {
+	r       io.Reader
+	in      []byte
+	encoded []byte
+	out     []byte
+	eof     bool
+}
+
+func newBase64Reader(r io.Reader) *base64Reader {
+	//-- Read in multiples of 3 bytes so each chunk encodes without padding
+	return &base64Reader{r: r, in: make([]byte, 3*1024), encoded: make([]byte, 4*1024)}
+}
+
+func (b *base64Reader) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		if b.eof {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(b.r, b.in)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			b.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		b.out = b.encoded[:base64.StdEncoding.EncodedLen(n)]
+		base64.StdEncoding.Encode(b.out, b.in[:n])
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
@@ -0,0 +1,127 @@
+package apiLib
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SetRootCAs adds PEM encoded CA certificates to the system pool used to verify the server,
+// for instances behind a gateway using a private CA. Applies to both xmlmc and dav requests
+// err := conn.SetRootCAs(caPEM)
+func (xmlmc *XmlmcInstStruct) SetRootCAs(pemCerts []byte) error {
+	return xmlmc.updateTLSConfig(func(config *tls.Config) error {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if config.RootCAs != nil {
+			//-- The pool is shared with the transport being replaced so is copied before adding to it
+			pool = config.RootCAs.Clone()
+		}
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return ErrInvalidCA
+		}
+		config.RootCAs = pool
+		return nil
+	})
+}
+
+// SetRootCAFile adds the PEM encoded CA certificates in a file to the pool used to verify the server
+// err := conn.SetRootCAFile("/etc/ssl/private-ca.pem")
+func (xmlmc *XmlmcInstStruct) SetRootCAFile(path string) error {
+	pemCerts, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read CA file: %w", err)
+	}
+	return xmlmc.SetRootCAs(pemCerts)
+}
+
+// SetClientCertificate sets a PEM encoded client certificate and key presented for mutual TLS
+// err := conn.SetClientCertificate(certPEM, keyPEM)
+func (xmlmc *XmlmcInstStruct) SetClientCertificate(certPEM []byte, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("Unable to load client certificate: %w", err)
+	}
+	return xmlmc.updateTLSConfig(func(config *tls.Config) error {
+		config.Certificates = []tls.Certificate{cert}
+		return nil
+	})
+}
+
+// SetClientCertificateFile loads a PEM encoded client certificate and key from files for mutual TLS
+// err := conn.SetClientCertificateFile("client.crt", "client.key")
+func (xmlmc *XmlmcInstStruct) SetClientCertificateFile(certFile string, keyFile string) error {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read client certificate file: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read client key file: %w", err)
+	}
+	return xmlmc.SetClientCertificate(certPEM, keyPEM)
+}
+
+// SetMinTLSVersion sets the minimum TLS version, tls.VersionTLS12 or tls.VersionTLS13
+// err := conn.SetMinTLSVersion(tls.VersionTLS13)
+func (xmlmc *XmlmcInstStruct) SetMinTLSVersion(version uint16) error {
+	if version != tls.VersionTLS12 && version != tls.VersionTLS13 {
+		return ErrTLSVersion
+	}
+	return xmlmc.updateTLSConfig(func(config *tls.Config) error {
+		config.MinVersion = version
+		return nil
+	})
+}
+
+// SetPinnedPublicKeys only allows connections where a certificate in the server chain has one of the given
+// public keys, each the base64 encoded SHA-256 of the DER subject public key info as used by HPKP.
+// Normal certificate verification still applies. Calling it with no keys removes the pinning
+// err := conn.SetPinnedPublicKeys("sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+func (xmlmc *XmlmcInstStruct) SetPinnedPublicKeys(pins ...string) error {
+	if len(pins) == 0 {
+		return xmlmc.updateTLSConfig(func(config *tls.Config) error {
+			config.VerifyConnection = nil
+			return nil
+		})
+	}
+	allowed := make(map[string]bool)
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(pin, "sha256/")
+		if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("%w: %s", ErrInvalidPin, pin)
+		}
+		allowed[pin] = true
+	}
+	verify := func(state tls.ConnectionState) error {
+		for _, cert := range state.PeerCertificates {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if allowed[base64.StdEncoding.EncodeToString(sum[:])] {
+				return nil
+			}
+		}
+		return ErrPinMismatch
+	}
+	return xmlmc.updateTLSConfig(func(config *tls.Config) error {
+		config.VerifyConnection = verify
+		return nil
+	})
+}
+
+// updateTLSConfig applies update to the TLS config of a clone of the default transport, creating the config if needed
+func (xmlmc *XmlmcInstStruct) updateTLSConfig(update func(config *tls.Config) error) error {
+	return xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		return update(t.TLSClientConfig)
+	})
+}
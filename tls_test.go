@@ -0,0 +1,139 @@
+package apiLib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTLSTestServer returns a TLS server that answers xmlmc calls and dav requests
+func newTLSTestServer(config func(*tls.Config)) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set("X-Client-Cert", r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	ts.TLS = &tls.Config{}
+	if config != nil {
+		config(ts.TLS)
+	}
+	ts.StartTLS()
+	return ts
+}
+
+func serverCertPEM(ts *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+}
+
+func TestSetRootCAs(t *testing.T) {
+	ts := newTLSTestServer(nil)
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	if _, err := conn.Invoke("system", "pingCheck"); err == nil {
+		t.Errorf("Was expecting an unknown authority error")
+	}
+	if err := conn.SetRootCAs([]byte("not a certificate")); !errors.Is(err, ErrInvalidCA) {
+		t.Errorf("Was expecting an error for an invalid CA")
+	}
+	if err := conn.SetRootCAs(serverCertPEM(ts)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Errorf(err.Error())
+	}
+	resp, err := conn.DavRequest("GET", "/session/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/dav/session/file.txt" {
+		t.Errorf("Was expecting the dav endpoint to be used but got %s", resp.Request.URL.Path)
+	}
+}
+
+func TestSetMinTLSVersion(t *testing.T) {
+	ts := newTLSTestServer(func(config *tls.Config) { config.MaxVersion = tls.VersionTLS12 })
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	_ = conn.SetRootCAs(serverCertPEM(ts))
+	if err := conn.SetMinTLSVersion(tls.VersionTLS11); !errors.Is(err, ErrTLSVersion) {
+		t.Errorf("Was expecting an error for TLS 1.1")
+	}
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Errorf(err.Error())
+	}
+	_ = conn.SetMinTLSVersion(tls.VersionTLS13)
+	if _, err := conn.Invoke("system", "pingCheck"); err == nil {
+		t.Errorf("Was expecting the TLS 1.2 server to be refused")
+	}
+}
+
+func TestSetPinnedPublicKeys(t *testing.T) {
+	ts := newTLSTestServer(nil)
+	defer ts.Close()
+
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	_ = conn.SetRootCAs(serverCertPEM(ts))
+	if err := conn.SetPinnedPublicKeys("not a pin"); !errors.Is(err, ErrInvalidPin) {
+		t.Errorf("Was expecting an error for an invalid pin")
+	}
+	_ = conn.SetPinnedPublicKeys("sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+	if _, err := conn.Invoke("system", "pingCheck"); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("Was expecting the unpinned key to be refused got %v", err)
+	}
+	_ = conn.SetPinnedPublicKeys("sha256/" + base64.StdEncoding.EncodeToString(sum[:]))
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestSetClientCertificate(t *testing.T) {
+	ts := newTLSTestServer(func(config *tls.Config) { config.ClientAuth = tls.RequireAnyClientCert })
+	defer ts.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "goApiLib"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	conn := NewXmlmcInstance(ts.URL + "/xmlmc/")
+	_ = conn.SetRootCAs(serverCertPEM(ts))
+	if _, err := conn.Invoke("system", "pingCheck"); err == nil {
+		t.Errorf("Was expecting the server to require a client certificate")
+	}
+	err := conn.SetClientCertificate(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, headers, err := conn.InvokeGetResponse("system", "pingCheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("X-Client-Cert") != "goApiLib" {
+		t.Errorf("Was expecting the client certificate to be presented")
+	}
+}
@@ -0,0 +1,213 @@
+package apiLib
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultMaxIdleConnsPerHost is the number of idle connections kept per host by the default transport
+const defaultMaxIdleConnsPerHost = 16
+
+// httpClient returns the long lived client used for both xmlmc and dav requests.
+// Timeouts are applied per request so the same client is reused for every call
+func (xmlmc *XmlmcInstStruct) httpClient() *http.Client {
+	//-- The default client is created on first use, which may be by several calls at once
+	xmlmc.clientMu.Lock()
+	defer xmlmc.clientMu.Unlock()
+	if xmlmc.client != nil {
+		return xmlmc.client
+	}
+	if xmlmc.defaultClient == nil {
+		switch {
+		case xmlmc.roundTripper != nil:
+			xmlmc.defaultClient = &http.Client{Transport: xmlmc.roundTripper}
+		case xmlmc.transport != nil:
+			xmlmc.defaultClient = &http.Client{Transport: xmlmc.transport}
+		default:
+			log.Println("xmlmc.transport is nil")
+			xmlmc.defaultClient = &http.Client{}
+		}
+	}
+	return xmlmc.defaultClient
+}
+
+// GetHTTPClient returns the client used by this XmlmcInstance so its connection pool can be shared
+// with other instances using SetHTTPClient
+// client := conn.GetHTTPClient()
+func (xmlmc *XmlmcInstStruct) GetHTTPClient() *http.Client {
+	return xmlmc.httpClient()
+}
+
+// SetMaxIdleConnsPerHost sets how many idle connections are kept open for reuse per host, it defaults to 16.
+// High throughput workers should set this to at least the number of concurrent calls
+// conn.SetMaxIdleConnsPerHost(64)
+func (xmlmc *XmlmcInstStruct) SetMaxIdleConnsPerHost(n int) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		t.MaxIdleConnsPerHost = n
+		if n > t.MaxIdleConns {
+			t.MaxIdleConns = n
+		}
+		return nil
+	})
+}
+
+// SetIdleConnTimeout sets how long an idle connection is kept open for reuse, it defaults to 90 seconds
+// conn.SetIdleConnTimeout(2 * time.Minute)
+func (xmlmc *XmlmcInstStruct) SetIdleConnTimeout(d time.Duration) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		t.IdleConnTimeout = d
+		return nil
+	})
+}
+
+// SetHTTP2 enables or disables HTTP/2, which is enabled by default when the server supports it
+// conn.SetHTTP2(false)
+func (xmlmc *XmlmcInstStruct) SetHTTP2(enabled bool) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		t.ForceAttemptHTTP2 = enabled
+		if enabled {
+			t.TLSNextProto = nil
+			return nil
+		}
+		//-- A non nil empty map stops the transport negotiating HTTP/2, and h2 must no longer be offered
+		//-- if a request has already added it to the TLS config
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if t.TLSClientConfig != nil {
+			var protos []string
+			for _, proto := range t.TLSClientConfig.NextProtos {
+				if proto != "h2" {
+					protos = append(protos, proto)
+				}
+			}
+			t.TLSClientConfig.NextProtos = protos
+		}
+		return nil
+	})
+}
+
+// SetKeepAlive sets the TCP keep-alive period of connections, it defaults to 30 seconds.
+// A negative duration disables keep-alives so a new connection is made for every request
+// conn.SetKeepAlive(15 * time.Second)
+func (xmlmc *XmlmcInstStruct) SetKeepAlive(d time.Duration) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		dialer.KeepAlive = d
+		t.DisableKeepAlives = d < 0
+		return nil
+	})
+}
+
+// SetDialTimeout sets the maximum time to establish a TCP connection, it defaults to 30 seconds
+// conn.SetDialTimeout(5 * time.Second)
+func (xmlmc *XmlmcInstStruct) SetDialTimeout(d time.Duration) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		dialer.Timeout = d
+		return nil
+	})
+}
+
+// SetTLSHandshakeTimeout sets the maximum time for the TLS handshake, it defaults to 10 seconds
+// conn.SetTLSHandshakeTimeout(5 * time.Second)
+func (xmlmc *XmlmcInstStruct) SetTLSHandshakeTimeout(d time.Duration) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		t.TLSHandshakeTimeout = d
+		return nil
+	})
+}
+
+// SetResponseHeaderTimeout sets the maximum time to wait for the response headers once the request
+// has been sent, it defaults to 0 which means only the overall timeout applies
+// conn.SetResponseHeaderTimeout(20 * time.Second)
+func (xmlmc *XmlmcInstStruct) SetResponseHeaderTimeout(d time.Duration) {
+	xmlmc.updateTransport(func(t *http.Transport, dialer *net.Dialer) error {
+		t.ResponseHeaderTimeout = d
+		return nil
+	})
+}
+
+// detachSharedClient stops using the client shared by a Manager so transport options set on the instance
+// apply to its own transport, clientMu must be held
+func (xmlmc *XmlmcInstStruct) detachSharedClient() {
+	if xmlmc.sharedClient {
+		xmlmc.client = nil
+		xmlmc.sharedClient = false
+	}
+}
+
+// updateTransport applies update to a clone of the default transport and its dialer and swaps the clone in,
+// as a transport must not be changed once it is in use. Requests in flight finish on the old transport,
+// whose idle connections are closed. A client from GetHTTPClient keeps using the old transport
+func (xmlmc *XmlmcInstStruct) updateTransport(update func(t *http.Transport, dialer *net.Dialer) error) error {
+	xmlmc.clientMu.Lock()
+	defer xmlmc.clientMu.Unlock()
+	if xmlmc.transport == nil {
+		return ErrNoTransport
+	}
+	xmlmc.detachSharedClient()
+	t := xmlmc.transport.Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if xmlmc.dialer != nil {
+		copied := *xmlmc.dialer
+		dialer = &copied
+	}
+	t.DialContext = dialer.DialContext
+	if err := update(t, dialer); err != nil {
+		return err
+	}
+	old := xmlmc.transport
+	xmlmc.transport, xmlmc.dialer = t, dialer
+	if xmlmc.defaultClient != nil && xmlmc.defaultClient.Transport == old {
+		xmlmc.defaultClient = &http.Client{Transport: t}
+	}
+	old.CloseIdleConnections()
+	return nil
+}
+
+// SetMethodTimeout overrides the overall timeout for calls to a service method, or to every method
+// of the service when methodname is empty. A timeout of 0 means no timeout for those calls
+// conn.SetMethodTimeout("reporting", "reportRun", 10*time.Minute)
+func (xmlmc *XmlmcInstStruct) SetMethodTimeout(servicename string, methodname string, timeout time.Duration) {
+	if xmlmc.methodTimeouts == nil {
+		xmlmc.methodTimeouts = make(map[string]time.Duration)
+	}
+	xmlmc.methodTimeouts[servicename+"::"+methodname] = timeout
+}
+
+// callTimeout returns the timeout for a call to a service method
+func (xmlmc *XmlmcInstStruct) callTimeout(servicename string, methodname string) time.Duration {
+	if timeout, ok := xmlmc.methodTimeouts[servicename+"::"+methodname]; ok {
+		return timeout
+	}
+	if timeout, ok := xmlmc.methodTimeouts[servicename+"::"]; ok {
+		return timeout
+	}
+	return xmlmc.timeout
+}
+
+// timeoutContext returns the context for a request within parent limited by the timeout of the service method
+func (xmlmc *XmlmcInstStruct) timeoutContext(parent context.Context, servicename string, methodname string) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	timeout := xmlmc.callTimeout(servicename, methodname)
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// cancelOnClose cancels the context of a request once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,153 @@
+package apiLib
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCountingServer returns a test server answering pingCheck and a counter of the connections made to it
+func newCountingServer() (*httptest.Server, *atomic.Int64) {
+	conns := &atomic.Int64{}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	return ts, conns
+}
+
+func TestConnectionReuse(t *testing.T) {
+	ts, conns := newCountingServer()
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	if conn.GetHTTPClient() != conn.GetHTTPClient() {
+		t.Errorf("Was expecting the same client to be reused")
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns.Load() != 1 {
+		t.Errorf("Was expecting 1 connection but got %d", conns.Load())
+	}
+
+	conn.SetKeepAlive(-1)
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns.Load() != 4 {
+		t.Errorf("Was expecting a new connection per call without keep-alives but got %d", conns.Load())
+	}
+}
+
+func TestTimeoutContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	conn.SetTimeoutDuration(100 * time.Millisecond)
+	if _, err := conn.Invoke("system", "pingCheck"); err == nil {
+		t.Errorf("Was expecting the call to time out")
+	}
+	conn.SetMethodTimeout("system", "pingCheck", 2*time.Second)
+	if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+		t.Errorf("Was expecting the method timeout to allow the call: %s", err)
+	}
+	conn.SetResponseHeaderTimeout(100 * time.Millisecond)
+	if _, err := conn.Invoke("system", "pingCheck"); err == nil {
+		t.Errorf("Was expecting the response header timeout to apply")
+	}
+}
+
+func TestSetHTTP2AfterFirstRequest(t *testing.T) {
+	var proto atomic.Value
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto.Store(r.Proto)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	if err := conn.SetRootCAs(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})); err != nil {
+		t.Fatal(err)
+	}
+	client := conn.GetHTTPClient()
+	for _, tt := range []struct {
+		enabled bool
+		proto   string
+	}{{true, "HTTP/2.0"}, {false, "HTTP/1.1"}, {true, "HTTP/2.0"}} {
+		conn.SetHTTP2(tt.enabled)
+		if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+			t.Fatal(err)
+		}
+		if proto.Load() != tt.proto {
+			t.Errorf("Was expecting %s with HTTP/2 %t but got %v", tt.proto, tt.enabled, proto.Load())
+		}
+	}
+	if conn.GetHTTPClient() == client {
+		t.Errorf("Was expecting the client to use the new transport")
+	}
+}
+
+// benchmarkInvokeParallel runs pingCheck from parallel instances sharing one connection pool
+func benchmarkInvokeParallel(b *testing.B, idleConnsPerHost int) {
+	ts, conns := newCountingServer()
+	defer ts.Close()
+
+	shared := NewXmlmcInstance(ts.URL)
+	shared.SetMaxIdleConnsPerHost(idleConnsPerHost)
+	client := shared.GetHTTPClient()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		conn := NewXmlmcInstance(ts.URL)
+		conn.SetHTTPClient(client)
+		for pb.Next() {
+			if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(conns.Load()), "conns")
+}
+
+func BenchmarkInvoke(b *testing.B) {
+	ts, conns := newCountingServer()
+	defer ts.Close()
+
+	conn := NewXmlmcInstance(ts.URL)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Invoke("system", "pingCheck"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(conns.Load()), "conns")
+}
+
+func BenchmarkInvokeParallelIdle2(b *testing.B) {
+	benchmarkInvokeParallel(b, 2)
+}
+
+func BenchmarkInvokeParallelIdle64(b *testing.B) {
+	benchmarkInvokeParallel(b, 64)
+}